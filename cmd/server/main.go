@@ -2,15 +2,48 @@ package main
 
 import (
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"os"
 	"pitch-perfect-server/internal/api"
 	"pitch-perfect-server/internal/core"
 	database "pitch-perfect-server/internal/db"
+	"strconv"
 )
 
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	if policy, ok := os.LookupEnv("CONNECTION_POLICY"); ok {
+		connectionPolicy, err := core.ParseConnectionPolicy(policy)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		core.ConnectionPolicy = connectionPolicy
+	}
+	if policy, ok := os.LookupEnv("SLOW_CONSUMER_POLICY"); ok {
+		slowConsumerPolicy, err := core.ParseSlowConsumerPolicy(policy)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		core.SlowConsumerPolicy = slowConsumerPolicy
+	}
+	if size, ok := os.LookupEnv("QUEUE_SIZE"); ok {
+		queueSize, err := strconv.Atoi(size)
+		if err != nil || queueSize < 1 {
+			log.Fatal().Str("QUEUE_SIZE", size).Msg("Invalid queue size")
+		}
+		core.QueueSize = queueSize
+	}
+	if allow, ok := os.LookupEnv("ALLOW_FIXED_SEED"); ok {
+		allowFixedSeed, err := strconv.ParseBool(allow)
+		if err != nil {
+			log.Fatal().Str("ALLOW_FIXED_SEED", allow).Msg("Invalid fixed seed option")
+		}
+		core.AllowFixedSeed = allowFixedSeed
+	}
 	database.Init()
-	_ = core.InitConfig()
+	if err := core.InitConfig(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid game configuration")
+	}
 	_ = core.InitRooms()
 	api.Serve()
 }
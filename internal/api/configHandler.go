@@ -2,8 +2,9 @@ package api
 
 import (
 	"net/http"
+	"pitch-perfect-server/internal/core"
 )
 
 func ConfigHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./config/1/game_configuration.json")
+	http.ServeFile(w, r, core.ConfigPath)
 }
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/core"
+	"strconv"
+)
+
+func GamesHandler(w http.ResponseWriter, r *http.Request) {
+	playerId, err := checkToken(r)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if fields := validatePage(page, pageSize); len(fields) > 0 {
+		errorResponse(w, apperr.New(apperr.InvalidMessage, apperr.Params{"fields": fields}, "invalid pagination"))
+		return
+	}
+
+	games, err := getGamesPage(playerId, page, pageSize)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, games)
+}
+
+func GameHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := checkToken(r); err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	gameId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		errorResponse(w, apperr.New(apperr.InvalidMessage, apperr.Params{"field": "id"}, "invalid game id"))
+		return
+	}
+
+	game, err := core.GetGame(gameId)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, game)
+}
+
+func GameReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := checkToken(r); err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	gameId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		errorResponse(w, apperr.New(apperr.InvalidMessage, apperr.Params{"field": "id"}, "invalid game id"))
+		return
+	}
+
+	replay, err := core.ExportReplay(gameId)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"replay-"+gameId.String()+".json\"")
+	jsonResponse(w, replay)
+}
+
+func getGamesPage(playerId uuid.UUID, page int, pageSize int) (GamesPage, error) {
+	page = max(page, 1)
+	if pageSize == 0 {
+		pageSize = core.DefaultPageSize
+	}
+
+	games, total, err := core.GetPlayerGames(playerId, page, pageSize)
+	if err != nil {
+		return GamesPage{}, err
+	}
+	return GamesPage{Games: games, Page: page, PageSize: pageSize, Total: total}, nil
+}
+
+func jsonResponse(w http.ResponseWriter, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(payload)
+	if err != nil {
+		return
+	}
+}
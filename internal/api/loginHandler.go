@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/google/uuid"
 	"net/http"
+	"pitch-perfect-server/internal/apperr"
 	"pitch-perfect-server/internal/auth"
 	"pitch-perfect-server/internal/core"
 )
@@ -21,17 +22,21 @@ type LoginResponse struct {
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var loginRequest LoginRequest
 	err := json.NewDecoder(r.Body).Decode(&loginRequest)
+	if err != nil {
+		errorResponse(w, apperr.New(apperr.InvalidMessage, nil, "malformed login request: %s", err.Error()))
+		return
+	}
 
 	if len(loginRequest.Token) == 0 {
 		player, err := core.AddPlayer(loginRequest.Name)
 		if err != nil {
-			errorResponse(w)
+			errorResponse(w, err)
 			return
 		}
 
 		token, err := auth.GenerateToken(player.ID)
 		if err != nil {
-			errorResponse(w)
+			errorResponse(w, err)
 			return
 		}
 
@@ -41,7 +46,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	id, err := auth.CheckToken(loginRequest.Token)
 	if err != nil {
-		errorResponse(w)
+		errorResponse(w, err)
 		return
 	}
 
@@ -57,7 +62,23 @@ func okResponse(playerId uuid.UUID, token string, w http.ResponseWriter) {
 	}
 }
 
-func errorResponse(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusBadRequest)
-	return
+func errorResponse(w http.ResponseWriter, err error) {
+	appErr := apperr.From(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus(appErr.Code))
+	_ = json.NewEncoder(w).Encode(appErr)
+}
+
+func httpStatus(code apperr.Code) int {
+	switch code {
+	case apperr.Internal:
+		return http.StatusInternalServerError
+	case apperr.InvalidToken:
+		return http.StatusUnauthorized
+	case apperr.PlayerNotFound, apperr.RoomNotFound, apperr.GameNotFound:
+		return http.StatusNotFound
+	case apperr.ReplayUnavailable:
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
 }
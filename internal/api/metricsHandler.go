@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"pitch-perfect-server/internal/core"
+)
+
+// MetricsResponse only holds totals, the endpoint is public and must not tell
+// who is connected or flagged.
+type MetricsResponse struct {
+	Connections      int
+	QueuedEvents     int
+	MaxQueueDepth    int64
+	DroppedEvents    uint64
+	Violations       uint
+	ViolatingPlayers int
+}
+
+func MetricsHandler(w http.ResponseWriter, _ *http.Request) {
+	stats := core.GetConnectionsStats()
+	violations := core.GetViolations()
+	payload := MetricsResponse{Connections: len(stats), ViolatingPlayers: len(violations)}
+	for _, connection := range stats {
+		payload.QueuedEvents += connection.Depth
+		payload.MaxQueueDepth = max(payload.MaxQueueDepth, connection.MaxDepth)
+		payload.DroppedEvents += connection.Dropped
+	}
+	for _, count := range violations {
+		payload.Violations += count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(payload)
+	if err != nil {
+		return
+	}
+}
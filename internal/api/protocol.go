@@ -0,0 +1,560 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/core"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const ProtocolVersion uint = 1
+
+var SupportedProtocolVersions = []uint{1}
+
+const MaxRequestIdLength = 64
+
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		reasons = append(reasons, field.Field+": "+field.Reason)
+	}
+	return "invalid message: " + strings.Join(reasons, ", ")
+}
+
+type Command interface {
+	Validate() []FieldError
+}
+
+type Envelope struct {
+	Type      string
+	RequestId string
+}
+
+type Response struct {
+	Type      string
+	RequestId string
+}
+
+type CreateRoomCommand struct {
+	Envelope
+	RoomName string
+	Settings entities.RoomSettings
+}
+
+func (c *CreateRoomCommand) Validate() []FieldError {
+	if len(strings.TrimSpace(c.RoomName)) == 0 {
+		return []FieldError{{Field: "RoomName", Reason: "required"}}
+	}
+	return nil
+}
+
+type GetRoomsCommand struct {
+	Envelope
+}
+
+func (c *GetRoomsCommand) Validate() []FieldError {
+	return nil
+}
+
+type RoomCommand struct {
+	Envelope
+	RoomId uuid.UUID
+}
+
+func (c *RoomCommand) Validate() []FieldError {
+	return validateRoomId(c.RoomId)
+}
+
+type UpdateRoomSettingsCommand struct {
+	Envelope
+	RoomId   uuid.UUID
+	Settings entities.RoomSettings
+}
+
+func (c *UpdateRoomSettingsCommand) Validate() []FieldError {
+	return validateRoomId(c.RoomId)
+}
+
+type PlayerCardsSelectedCommand struct {
+	Envelope
+	RoomId uuid.UUID
+	Cards  []uint
+}
+
+func (c *PlayerCardsSelectedCommand) Validate() []FieldError {
+	fields := validateRoomId(c.RoomId)
+	if len(c.Cards) == 0 {
+		fields = append(fields, FieldError{Field: "Cards", Reason: "required"})
+	}
+	return fields
+}
+
+type PlayerRatedOtherCardsCommand struct {
+	Envelope
+	RoomId  uuid.UUID
+	Reviews map[uuid.UUID]bool
+	Ranking []uuid.UUID
+	Points  map[uuid.UUID]uint
+	Stars   map[uuid.UUID]uint
+	Best    uuid.UUID
+}
+
+func (c *PlayerRatedOtherCardsCommand) Validate() []FieldError {
+	fields := validateRoomId(c.RoomId)
+	if c.Reviews == nil && c.Ranking == nil && c.Points == nil && c.Stars == nil && c.Best == uuid.Nil {
+		fields = append(fields, FieldError{Field: "Reviews", Reason: "one of Reviews, Ranking, Points, Stars or Best required"})
+	}
+	return fields
+}
+
+func (c *PlayerRatedOtherCardsCommand) ballot() core.Ballot {
+	return core.Ballot{Likes: c.Reviews, Ranking: c.Ranking, Points: c.Points, Stars: c.Stars, Best: c.Best}
+}
+
+type MulliganCommand struct {
+	Envelope
+	RoomId uuid.UUID
+	Cards  []uint
+}
+
+func (c *MulliganCommand) Validate() []FieldError {
+	fields := validateRoomId(c.RoomId)
+	if len(c.Cards) == 0 {
+		fields = append(fields, FieldError{Field: "Cards", Reason: "required"})
+	}
+	return fields
+}
+
+type SwapCardCommand struct {
+	Envelope
+	RoomId uuid.UUID
+	Card   uint
+}
+
+func (c *SwapCardCommand) Validate() []FieldError {
+	fields := validateRoomId(c.RoomId)
+	if c.Card == 0 {
+		fields = append(fields, FieldError{Field: "Card", Reason: "required"})
+	}
+	return fields
+}
+
+type BetCommand struct {
+	Envelope
+	RoomId   uuid.UUID
+	Category uint
+	Stake    uint
+}
+
+func (c *BetCommand) Validate() []FieldError {
+	fields := validateRoomId(c.RoomId)
+	if c.Category == 0 {
+		fields = append(fields, FieldError{Field: "Category", Reason: "required"})
+	}
+	if c.Stake == 0 {
+		fields = append(fields, FieldError{Field: "Stake", Reason: "required"})
+	}
+	return fields
+}
+
+type ForecastCommand struct {
+	Envelope
+	RoomId   uuid.UUID
+	Category uint
+}
+
+func (c *ForecastCommand) Validate() []FieldError {
+	fields := validateRoomId(c.RoomId)
+	if c.Category == 0 {
+		fields = append(fields, FieldError{Field: "Category", Reason: "required"})
+	}
+	return fields
+}
+
+type GetGamesCommand struct {
+	Envelope
+	Page     int
+	PageSize int
+}
+
+func (c *GetGamesCommand) Validate() []FieldError {
+	return validatePage(c.Page, c.PageSize)
+}
+
+type GetGameCommand struct {
+	Envelope
+	GameId uuid.UUID
+}
+
+func (c *GetGameCommand) Validate() []FieldError {
+	if c.GameId == uuid.Nil {
+		return []FieldError{{Field: "GameId", Reason: "required"}}
+	}
+	return nil
+}
+
+type ReplayRoomCommand struct {
+	Envelope
+	GameId uuid.UUID
+	Speed  float64
+}
+
+func (c *ReplayRoomCommand) Validate() []FieldError {
+	fields := make([]FieldError, 0)
+	if c.GameId == uuid.Nil {
+		fields = append(fields, FieldError{Field: "GameId", Reason: "required"})
+	}
+	if c.Speed < 0 || c.Speed > core.MaxReplaySpeed {
+		fields = append(fields, FieldError{Field: "Speed", Reason: fmt.Sprintf("must be between 0 and %g", core.MaxReplaySpeed)})
+	}
+	return fields
+}
+
+type StopReplayCommand struct {
+	Envelope
+}
+
+func (c *StopReplayCommand) Validate() []FieldError {
+	return nil
+}
+
+type ClockSyncCommand struct {
+	Envelope
+	ClientTime int64
+}
+
+func (c *ClockSyncCommand) Validate() []FieldError {
+	if c.ClientTime <= 0 {
+		return []FieldError{{Field: "ClientTime", Reason: "must be a positive unix time in milliseconds"}}
+	}
+	return nil
+}
+
+func validatePage(page int, pageSize int) []FieldError {
+	fields := make([]FieldError, 0)
+	if page < 0 {
+		fields = append(fields, FieldError{Field: "Page", Reason: "must not be negative"})
+	}
+	if pageSize < 0 || pageSize > core.MaxPageSize {
+		fields = append(fields, FieldError{Field: "PageSize", Reason: fmt.Sprintf("must be between 0 and %d", core.MaxPageSize)})
+	}
+	return fields
+}
+
+func validateRoomId(roomId uuid.UUID) []FieldError {
+	if roomId == uuid.Nil {
+		return []FieldError{{Field: "RoomId", Reason: "required"}}
+	}
+	return nil
+}
+
+func newCommand(msgType string) (Command, bool) {
+	switch msgType {
+	case "CreateRoom":
+		return &CreateRoomCommand{}, true
+	case "GetRooms":
+		return &GetRoomsCommand{}, true
+	case "JoinRoom", "LeaveRoom", "PlayerReady", "Rematch":
+		return &RoomCommand{}, true
+	case "UpdateRoomSettings":
+		return &UpdateRoomSettingsCommand{}, true
+	case "PlayerCardsSelected":
+		return &PlayerCardsSelectedCommand{}, true
+	case "PlayerRatedOtherCards":
+		return &PlayerRatedOtherCardsCommand{}, true
+	case "Mulligan":
+		return &MulliganCommand{}, true
+	case "SwapCard":
+		return &SwapCardCommand{}, true
+	case "Bet":
+		return &BetCommand{}, true
+	case "Forecast":
+		return &ForecastCommand{}, true
+	case "GetGames":
+		return &GetGamesCommand{}, true
+	case "GetGame":
+		return &GetGameCommand{}, true
+	case "ReplayRoom":
+		return &ReplayRoomCommand{}, true
+	case "StopReplay":
+		return &StopReplayCommand{}, true
+	case "ClockSync":
+		return &ClockSyncCommand{}, true
+	}
+	return nil, false
+}
+
+func decodeCommand(data []byte) (Envelope, Command, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return envelope, nil, &ValidationError{Fields: []FieldError{{Field: "", Reason: "malformed json"}}}
+	}
+
+	if len(envelope.Type) == 0 {
+		return envelope, nil, &ValidationError{Fields: []FieldError{{Field: "Type", Reason: "required"}}}
+	}
+
+	if len(envelope.RequestId) == 0 || len(envelope.RequestId) > MaxRequestIdLength {
+		return envelope, nil, &ValidationError{Fields: []FieldError{{Field: "RequestId", Reason: fmt.Sprintf("required, at most %d characters", MaxRequestIdLength)}}}
+	}
+
+	command, ok := newCommand(envelope.Type)
+	if !ok {
+		return envelope, nil, &ValidationError{Fields: []FieldError{{Field: "Type", Reason: "unknown message type"}}}
+	}
+
+	if err := strictDecode(data, command); err != nil {
+		fieldError := decodeFieldError(err)
+		if len(fieldError.Field) == 0 {
+			fieldError.Field = findInvalidField(envelope.Type, data)
+		}
+		return envelope, nil, &ValidationError{Fields: []FieldError{fieldError}}
+	}
+
+	if fields := command.Validate(); len(fields) > 0 {
+		return envelope, nil, &ValidationError{Fields: fields}
+	}
+
+	return envelope, command, nil
+}
+
+func strictDecode(data []byte, target interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("trailing data after message")
+	}
+	return nil
+}
+
+// findInvalidField decodes the message one field at a time to name the field
+// rejected by a text unmarshaler, since encoding/json does not report it.
+func findInvalidField(msgType string, data []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+
+	for name, value := range fields {
+		command, _ := newCommand(msgType)
+		single, err := json.Marshal(map[string]json.RawMessage{name: value})
+		if err != nil {
+			continue
+		}
+		if strictDecode(single, command) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func decodeFieldError(err error) FieldError {
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return FieldError{Field: typeError.Field, Reason: fmt.Sprintf("expected %s", typeError.Type.String())}
+	}
+
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &syntaxError) {
+		return FieldError{Field: "", Reason: "malformed json at offset " + strconv.FormatInt(syntaxError.Offset, 10)}
+	}
+
+	message := err.Error()
+	if field, ok := strings.CutPrefix(message, "json: unknown field "); ok {
+		return FieldError{Field: strings.Trim(field, "\""), Reason: "unknown field"}
+	}
+
+	return FieldError{Field: "", Reason: message}
+}
+
+func negotiateVersion(requested string) (uint, error) {
+	if len(requested) == 0 {
+		return ProtocolVersion, nil
+	}
+
+	version, err := strconv.ParseUint(requested, 10, 32)
+	if err != nil || !slices.Contains(SupportedProtocolVersions, uint(version)) {
+		params := apperr.Params{"requested": requested, "supported": SupportedProtocolVersions}
+		return 0, apperr.New(apperr.UnsupportedVersion, params, "unsupported protocol version %s, supported versions are %v", requested, SupportedProtocolVersions)
+	}
+
+	return uint(version), nil
+}
+
+type WelcomeMessage struct {
+	Type              string
+	Version           uint
+	SupportedVersions []uint
+	ConnectionId      uuid.UUID
+}
+
+type AckMessage struct {
+	Response
+	Command string
+}
+
+type ErrorMessage struct {
+	Response
+	Command string
+	Code    apperr.Code
+	Error   string
+	Params  apperr.Params `json:",omitempty"`
+	Fields  []FieldError  `json:",omitempty"`
+}
+
+type CreateRoomResponse struct {
+	Response
+	RoomId uuid.UUID
+}
+
+type GetRoomsResponse struct {
+	Response
+	Rooms []entities.Room
+}
+
+type GamesPage struct {
+	Games    []entities.Game
+	Page     int
+	PageSize int
+	Total    int64
+}
+
+type GetGamesResponse struct {
+	Response
+	GamesPage
+}
+
+type GetGameResponse struct {
+	Response
+	Game entities.Game
+}
+
+type ClockSyncResponse struct {
+	Response
+	ClientTime int64
+	ServerTime int64
+}
+
+type RoomJoinedEvent struct {
+	Type   string
+	Player entities.Player
+}
+
+type PlayerIdEvent struct {
+	Type     string
+	PlayerId uuid.UUID
+}
+
+type RoomEvent struct {
+	Type string
+	Room entities.Room
+}
+
+type GameStartedEvent struct {
+	Type       string
+	Trends     map[uint]uint
+	Deadline   int64
+	ServerTime int64
+}
+
+type TurnStartedEvent struct {
+	Type       string
+	Cards      []entities.Word
+	Phrase     entities.Phrase
+	Deadline   int64
+	ServerTime int64
+}
+
+type AllPlayerSelectedCardsEvent struct {
+	Type         string
+	PlayersCards map[uuid.UUID][]uint
+	Deadline     int64
+	ServerTime   int64
+}
+
+type TurnEndedEvent struct {
+	Type         string
+	Trends       map[uint]uint
+	Leaderboards map[uuid.UUID]uint
+	Result       map[uuid.UUID]uint
+	Breakdown    map[uuid.UUID]core.PlayerScore
+	Votes        map[uuid.UUID]uint
+	Outcome      core.ReviewOutcome
+	Handles      map[uuid.UUID]uuid.UUID `json:",omitempty"`
+	TrendHistory []map[uint]uint
+	LastTurn     bool
+}
+
+type HandUpdatedEvent struct {
+	Type  string
+	Cards []entities.Word
+}
+
+type TrendShiftEvent struct {
+	Type   string
+	Shift  core.TrendShift
+	Trends map[uint]uint
+}
+
+type ForecastEvent struct {
+	Type     string
+	Forecast core.TrendForecast
+}
+
+type GameEndedEvent struct {
+	Type         string
+	GameId       uuid.UUID
+	Standings    []core.Standing
+	Awards       []core.Award
+	Leaderboards map[uuid.UUID]uint
+	TrendHistory []map[uint]uint
+	Deadline     int64
+	ServerTime   int64
+}
+
+type RoomSnapshotEvent struct {
+	Type         string
+	Room         entities.Room
+	Turn         uint
+	Cards        []entities.Word
+	Phrase       entities.Phrase
+	Trends       map[uint]uint
+	PlayersCards map[uuid.UUID][]uint
+	Leaderboards map[uuid.UUID]uint
+	Standings    []core.Standing `json:",omitempty"`
+	Awards       []core.Award    `json:",omitempty"`
+	Deadline     int64           `json:",omitempty"`
+	ServerTime   int64
+}
+
+type ReplayFrameEvent struct {
+	Type     string
+	GameId   uuid.UUID
+	PlayerId uuid.UUID
+	Event    interface{}
+}
+
+type ReplayEndedEvent struct {
+	Type   string
+	GameId uuid.UUID
+}
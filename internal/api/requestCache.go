@@ -0,0 +1,83 @@
+package api
+
+import (
+	"pitch-perfect-server/internal/apperr"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	requestCacheSize = 4096
+	requestCacheTTL  = 5 * time.Minute
+)
+
+type cachedResponse struct {
+	done     chan struct{}
+	msgType  string
+	expires  time.Time
+	response interface{}
+}
+
+// requestCache remembers the response of every mutating command by player and
+// request id, so a retried request is answered without being executed twice.
+// A request id reused for another command type is rejected, and responses
+// that a retry could change are not kept.
+type requestCache struct {
+	mutex   sync.Mutex
+	entries map[string]*cachedResponse
+	order   []string
+}
+
+var requests = requestCache{entries: make(map[string]*cachedResponse)}
+
+func (c *requestCache) do(key string, msgType string, f func() interface{}) interface{} {
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().Before(entry.expires) {
+		c.mutex.Unlock()
+		if entry.msgType != msgType {
+			return &ValidationError{Fields: []FieldError{{Field: "RequestId", Reason: "already used for a " + entry.msgType + " message"}}}
+		}
+		<-entry.done
+		return entry.response
+	}
+	if ok {
+		c.remove(key)
+	}
+
+	entry = &cachedResponse{done: make(chan struct{}), msgType: msgType, expires: time.Now().Add(requestCacheTTL)}
+	c.entries[key] = entry
+	c.order = append(c.order, key)
+	if len(c.order) > requestCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.mutex.Unlock()
+
+	entry.response = f()
+	close(entry.done)
+
+	if !cacheable(entry.response) {
+		c.mutex.Lock()
+		if c.entries[key] == entry {
+			c.remove(key)
+		}
+		c.mutex.Unlock()
+	}
+	return entry.response
+}
+
+func (c *requestCache) remove(key string) {
+	delete(c.entries, key)
+	if i := slices.Index(c.order, key); i >= 0 {
+		c.order = slices.Delete(c.order, i, i+1)
+	}
+}
+
+// cacheable rejects transient failures, the retry of a timed out command has
+// to reach the room again.
+func cacheable(response interface{}) bool {
+	message, ok := response.(ErrorMessage)
+	return !ok || message.Code != apperr.RoomTimeout
+}
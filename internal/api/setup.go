@@ -13,6 +13,10 @@ func Serve() {
 	r.HandleFunc("/ws", WsHandler)
 	r.HandleFunc("/login", LoginHandler)
 	r.HandleFunc("/config", ConfigHandler)
+	r.HandleFunc("/metrics", MetricsHandler)
+	r.HandleFunc("/games", GamesHandler)
+	r.HandleFunc("/games/{id}", GameHandler)
+	r.HandleFunc("/games/{id}/replay", GameReplayHandler)
 
 	credentials := handlers.AllowCredentials()
 	methods := handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS"})
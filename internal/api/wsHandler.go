@@ -2,10 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
 	"net/http"
+	"pitch-perfect-server/internal/apperr"
 	"pitch-perfect-server/internal/auth"
 	"pitch-perfect-server/internal/core"
 	"sync"
@@ -24,232 +26,232 @@ func WsHandler(w http.ResponseWriter, r *http.Request) {
 
 	playerId, err := checkToken(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		closeSocket(socket, err)
 		return
 	}
 
-	eventChannel, err := core.AddPlayerConnection(playerId)
+	version, err := negotiateVersion(r.URL.Query().Get("version"))
+	if err != nil {
+		closeSocket(socket, err)
+		return
+	}
+
+	connection, err := core.AddPlayerConnection(playerId)
 	if err != nil {
 		log.Error().AnErr("add_connection", err)
+		closeSocket(socket, err)
 		return
 	}
 
-	var room uuid.UUID
+	welcome := WelcomeMessage{Type: "Welcome", Version: version, SupportedVersions: SupportedProtocolVersions, ConnectionId: connection.Id}
+	if err := writeMessage(socket, &mutex, welcome); err != nil {
+		log.Err(err)
+	}
+
+	go listenEventChannel(connection, socket, &mutex)
 
-	go listenEventChannel(eventChannel, socket, &mutex)
+	_, err = core.ReconnectPlayer(playerId, connection.Id)
+	if err != nil {
+		log.Error().Err(err).Msg("Impossible to reconnect player to room")
+	}
 
 	for {
-		mt, bytes, err := socket.ReadMessage()
+		_, bytes, err := socket.ReadMessage()
 		if err != nil {
 			log.Err(err)
 			break
 		}
 
-		var msg map[string]interface{}
-		if err := json.Unmarshal(bytes, &msg); err != nil {
-			panic(err)
+		response := handleMessage(playerId, connection, bytes)
+		if response != nil {
+			if err := writeMessage(socket, &mutex, response); err != nil {
+				log.Err(err)
+				break
+			}
 		}
+	}
 
-		log.Info().Interface("msg", msg).Send()
+	lastConnection := core.RemovePlayerConnection(connection)
 
-		msgType, ok := msg["Type"]
-		if ok {
-			response := make(map[string]interface{})
-			response["Type"] = msgType
+	// Another socket may have joined or left a room since this one opened,
+	// the seat is looked up rather than remembered.
+	if lastConnection {
+		if err := core.DisconnectPlayer(playerId); err != nil {
+			log.Error().Err(err).Msg("Impossible to disconnect player from room")
+		}
+	}
 
-			switch msgType {
-			case "CreateRoom":
-				roomName, ok := msg["RoomName"].(string)
-				if !ok {
-					response["Error"] = "No room name"
-					break
-				}
+	log.Warn().Msg("Conn destroyed")
+}
 
-				roomId, err := core.CreateRoom(roomName)
-				if err != nil {
-					response["Error"] = err
-				}
+func handleMessage(playerId uuid.UUID, connection *core.Connection, data []byte) interface{} {
+	envelope, command, err := decodeCommand(data)
+	if err != nil {
+		return errorMessage(envelope, err)
+	}
 
-				response["RoomId"] = roomId
-				break
+	log.Info().Str("type", envelope.Type).Str("request", envelope.RequestId).Interface("msg", command).Send()
 
-			case "GetRooms":
-				rooms, err := core.GetAllRooms()
-				if err != nil {
-					log.Err(err)
-					break
-				}
+	if !isMutatingCommand(command) {
+		return executeCommand(playerId, connection, envelope, command)
+	}
 
-				response["Rooms"] = rooms
-				break
+	key := playerId.String() + "/" + envelope.RequestId
+	response := requests.do(key, envelope.Type, func() interface{} {
+		return executeCommand(playerId, connection, envelope, command)
+	})
+	if err, ok := response.(*ValidationError); ok {
+		return errorMessage(envelope, err)
+	}
+	return response
+}
 
-			case "JoinRoom":
-				roomIdStr, ok := msg["RoomId"].(string)
-				if !ok {
-					response["Error"] = "No room name"
-					break
-				}
-
-				roomId, err := uuid.Parse(roomIdStr)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
-
-				err = core.JoinRoom(playerId, roomId)
-				if err == nil {
-					room = roomId
-				}
-
-				response["Result"] = err == nil
-				break
+func isMutatingCommand(command Command) bool {
+	switch command.(type) {
+	case *GetRoomsCommand, *GetGamesCommand, *GetGameCommand, *ClockSyncCommand:
+		return false
+	}
+	return true
+}
 
-			case "LeaveRoom":
-				roomIdStr, ok := msg["RoomId"].(string)
-				if !ok {
-					response["Error"] = "No room name"
-					break
-				}
-
-				roomId, err := uuid.Parse(roomIdStr)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
-
-				err = core.LeaveRoom(playerId, roomId)
-				response["Result"] = err == nil
-				break
+func executeCommand(playerId uuid.UUID, connection *core.Connection, envelope Envelope, command Command) interface{} {
+	response := Response{Type: envelope.Type, RequestId: envelope.RequestId}
 
-			case "PlayerReady":
-				roomIdStr, ok := msg["RoomId"].(string)
-				if !ok {
-					response["Error"] = "No room id"
-					break
-				}
+	switch cmd := command.(type) {
+	case *CreateRoomCommand:
+		roomId, err := core.CreateRoom(playerId, cmd.RoomName, cmd.Settings)
+		if err != nil {
+			return errorMessage(envelope, err)
+		}
 
-				roomId, err := uuid.Parse(roomIdStr)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
+		return CreateRoomResponse{Response: response, RoomId: roomId}
 
-				c, err := core.GetChannelByRoom(roomId)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
+	case *ClockSyncCommand:
+		return ClockSyncResponse{Response: response, ClientTime: cmd.ClientTime, ServerTime: core.RoomClock.Now().UnixMilli()}
 
-				response = nil
+	case *GetRoomsCommand:
+		rooms, err := core.GetAllRooms()
+		if err != nil {
+			return errorMessage(envelope, err)
+		}
 
-				*c <- core.RoomCmd{Type: core.PlayerReady, PlayerId: playerId}
-				break
+		return GetRoomsResponse{Response: response, Rooms: rooms}
 
-			case "PlayerCardsSelected":
-				roomIdStr, ok := msg["RoomId"].(string)
-				if !ok {
-					response["Error"] = "No room id"
-					break
-				}
-
-				roomId, err := uuid.Parse(roomIdStr)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
-
-				cardsData, ok := msg["Cards"].([]interface{})
-				if !ok {
-					response["Error"] = "No player cards"
-					break
-				}
-				cards := make([]uint, len(cardsData))
-				for k, v := range cardsData {
-					cards[k] = uint(v.(float64))
-				}
-
-				c, err := core.GetChannelByRoom(roomId)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
-
-				response = nil
-
-				*c <- core.RoomCmd{Type: core.PlayerCardsSelected, PlayerId: playerId, Cards: cards}
-				break
+	case *GetGamesCommand:
+		games, err := getGamesPage(playerId, cmd.Page, cmd.PageSize)
+		if err != nil {
+			return errorMessage(envelope, err)
+		}
 
-			case "PlayerRatedOtherCards":
-				roomIdStr, ok := msg["RoomId"].(string)
-				if !ok {
-					response["Error"] = "No room id"
-					break
-				}
-
-				roomId, err := uuid.Parse(roomIdStr)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
-
-				data, ok := msg["Reviews"]
-				if !ok {
-					response["Error"] = "No review name"
-					break
-				}
-				reviews := make(map[uuid.UUID]bool)
-				for k, v := range data.(map[string]interface{}) {
-					id, err := uuid.Parse(k)
-					if err != nil {
-						response["Error"] = err
-						break
-					}
-
-					reviews[id] = v.(bool)
-				}
-
-				c, err := core.GetChannelByRoom(roomId)
-				if err != nil {
-					response["Error"] = err
-					break
-				}
-
-				response = nil
-
-				*c <- core.RoomCmd{Type: core.PlayerRatedOtherCards, PlayerId: playerId, Reviews: reviews}
-				break
+		return GetGamesResponse{Response: response, GamesPage: games}
 
-			default:
-				if err != nil {
-					log.Err(err)
-					break
-				}
-			}
+	case *GetGameCommand:
+		game, err := core.GetGame(cmd.GameId)
+		if err != nil {
+			return errorMessage(envelope, err)
+		}
 
-			if response != nil {
-				output, err := json.Marshal(response)
-				if err != nil {
-					log.Err(err)
-					break
-				}
-				mutex.Lock()
-				err = socket.WriteMessage(mt, output)
-				mutex.Unlock()
-				if err != nil {
-					log.Err(err)
-					break
-				}
-			}
+		return GetGameResponse{Response: response, Game: game}
+
+	case *ReplayRoomCommand:
+		err := core.ReplayGame(connection, cmd.GameId, cmd.Speed)
+		return ackOrError(envelope, err)
+
+	case *StopReplayCommand:
+		core.StopReplay(connection.Id)
+		return ackOrError(envelope, nil)
+
+	case *UpdateRoomSettingsCommand:
+		err := core.UpdateRoomSettings(playerId, cmd.RoomId, cmd.Settings)
+		return ackOrError(envelope, err)
+
+	case *RoomCommand:
+		switch envelope.Type {
+		case "JoinRoom":
+			err := core.JoinRoom(playerId, cmd.RoomId)
+			return ackOrError(envelope, err)
+
+		case "LeaveRoom":
+			err := core.LeaveRoom(playerId, cmd.RoomId)
+			return ackOrError(envelope, err)
+
+		case "PlayerReady":
+			err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.PlayerReady, PlayerId: playerId})
+			return ackOrError(envelope, err)
+
+		case "Rematch":
+			err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.Rematch, PlayerId: playerId})
+			return ackOrError(envelope, err)
 		}
+		break
+
+	case *PlayerCardsSelectedCommand:
+		err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.PlayerCardsSelected, PlayerId: playerId, Cards: cmd.Cards})
+		return ackOrError(envelope, err)
+
+	case *PlayerRatedOtherCardsCommand:
+		err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.PlayerRatedOtherCards, PlayerId: playerId, Ballot: cmd.ballot()})
+		return ackOrError(envelope, err)
+
+	case *MulliganCommand:
+		err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.PlayerMulligan, PlayerId: playerId, Cards: cmd.Cards})
+		return ackOrError(envelope, err)
+
+	case *SwapCardCommand:
+		err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.PlayerSwap, PlayerId: playerId, Cards: []uint{cmd.Card}})
+		return ackOrError(envelope, err)
+
+	case *BetCommand:
+		err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.PlayerBet, PlayerId: playerId, Category: cmd.Category, Stake: cmd.Stake})
+		return ackOrError(envelope, err)
+
+	case *ForecastCommand:
+		err := core.SendRoomCmd(cmd.RoomId, core.RoomCmd{Type: core.PlayerForecast, PlayerId: playerId, Category: cmd.Category})
+		return ackOrError(envelope, err)
 	}
 
-	if room != uuid.Nil {
-		_ = core.LeaveRoom(playerId, room)
+	return errorMessage(envelope, apperr.New(apperr.InvalidMessage, apperr.Params{"type": envelope.Type}, "unsupported message type %s", envelope.Type))
+}
+
+func ackOrError(envelope Envelope, err error) interface{} {
+	if err != nil {
+		return errorMessage(envelope, err)
 	}
+	return AckMessage{Response: Response{Type: "Ack", RequestId: envelope.RequestId}, Command: envelope.Type}
+}
 
-	*eventChannel <- core.PlayerEvent{Type: core.ConnectionDown}
+func errorMessage(envelope Envelope, err error) ErrorMessage {
+	response := ErrorMessage{Response: Response{Type: "Error", RequestId: envelope.RequestId}, Command: envelope.Type}
 
-	log.Warn().Msg("Conn destroyed")
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		response.Code = apperr.InvalidMessage
+		response.Error = validationError.Error()
+		response.Fields = validationError.Fields
+		return response
+	}
+
+	appErr := apperr.From(err)
+	response.Code = appErr.Code
+	response.Error = appErr.Message
+	response.Params = appErr.Params
+	return response
+}
+
+func writeMessage(socket *websocket.Conn, mutex *sync.Mutex, message interface{}) error {
+	output, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return socket.WriteMessage(websocket.TextMessage, output)
+}
+
+func closeSocket(socket *websocket.Conn, err error) {
+	closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, string(apperr.From(err).Code))
+	_ = socket.WriteMessage(websocket.CloseMessage, closeMessage)
 }
 
 func checkToken(r *http.Request) (uuid.UUID, error) {
@@ -257,68 +259,117 @@ func checkToken(r *http.Request) (uuid.UUID, error) {
 	return auth.CheckToken(token)
 }
 
-func listenEventChannel(c *chan core.PlayerEvent, socket *websocket.Conn, mt *sync.Mutex) {
+func listenEventChannel(connection *core.Connection, socket *websocket.Conn, mt *sync.Mutex) {
 	for {
-		if socket == nil {
-			break
-		}
-
-		if *c == nil {
-			break
+		var event core.PlayerEvent
+		select {
+		case event = <-connection.Events:
+		case <-connection.Done:
+			_ = socket.Close()
+			return
 		}
 
-		event := <-*c
-
-		response := make(map[string]interface{})
-		switch event.Type {
-		case core.RoomJoined:
-			response["Type"] = "RoomJoined"
-			response["Player"] = event.Player
-			break
-		case core.RoomLeaved:
-			response["Type"] = "RoomLeaved"
-			response["PlayerId"] = event.PlayerId
-			break
-		case core.GameStarted:
-			response["Type"] = "GameStarted"
-			response["Trends"] = event.Trends
-			break
-		case core.TurnStarted:
-			response["Type"] = "TurnStarted"
-			response["Cards"] = event.Cards
-			response["Phrase"] = event.Phrase
-			break
-		case core.AllPlayerSelectedCards:
-			response["Type"] = "AllPlayerSelectedCards"
-			response["PlayersCards"] = event.PlayersCards
-			break
-		case core.TurnEnded:
-			response["Type"] = "TurnEnded"
-			response["Trends"] = event.Trends
-			response["Leaderboards"] = event.Leaderboards
-			response["Result"] = event.Result
-			response["LastTurn"] = event.LastTurn
-			break
-		case core.RoomCreated:
-			response["Type"] = "RoomCreated"
-			response["Room"] = event.Room
-			break
-		case core.ConnectionDown:
-			return
-		default:
-			break
+		message := eventMessage(event)
+		if message == nil {
+			continue
 		}
 
-		output, err := json.Marshal(response)
-		if err != nil {
+		if err := writeMessage(socket, mt, message); err != nil {
 			log.Err(err)
 		}
+	}
+}
 
-		mt.Lock()
-		err = socket.WriteMessage(1, output)
-		mt.Unlock()
-		if err != nil {
-			log.Err(err)
+func eventMessage(event core.PlayerEvent) interface{} {
+	switch event.Type {
+	case core.RoomJoined:
+		return RoomJoinedEvent{Type: "RoomJoined", Player: event.Player}
+	case core.RoomLeaved:
+		return PlayerIdEvent{Type: "RoomLeaved", PlayerId: event.PlayerId}
+	case core.GameStarted:
+		return GameStartedEvent{
+			Type:       "GameStarted",
+			Trends:     event.Trends,
+			Deadline:   event.Deadline.UnixMilli(),
+			ServerTime: event.ServerTime.UnixMilli(),
+		}
+	case core.TurnStarted:
+		return TurnStartedEvent{
+			Type:       "TurnStarted",
+			Cards:      event.Cards,
+			Phrase:     event.Phrase,
+			Deadline:   event.Deadline.UnixMilli(),
+			ServerTime: event.ServerTime.UnixMilli(),
+		}
+	case core.AllPlayerSelectedCards:
+		return AllPlayerSelectedCardsEvent{
+			Type:         "AllPlayerSelectedCards",
+			PlayersCards: event.PlayersCards,
+			Deadline:     event.Deadline.UnixMilli(),
+			ServerTime:   event.ServerTime.UnixMilli(),
+		}
+	case core.TurnEnded:
+		return TurnEndedEvent{
+			Type:         "TurnEnded",
+			Trends:       event.Trends,
+			Leaderboards: event.Leaderboards,
+			Result:       event.Result,
+			Breakdown:    event.Breakdown,
+			Votes:        event.Votes,
+			Outcome:      event.Outcome,
+			Handles:      event.Handles,
+			TrendHistory: event.TrendHistory,
+			LastTurn:     event.LastTurn,
+		}
+	case core.RoomCreated:
+		return RoomEvent{Type: "RoomCreated", Room: event.Room}
+	case core.RoomUpdated:
+		return RoomEvent{Type: "RoomUpdated", Room: event.Room}
+	case core.PlayerDisconnected:
+		return PlayerIdEvent{Type: "PlayerDisconnected", PlayerId: event.PlayerId}
+	case core.PlayerReconnected:
+		return PlayerIdEvent{Type: "PlayerReconnected", PlayerId: event.PlayerId}
+	case core.HandUpdated:
+		return HandUpdatedEvent{Type: "HandUpdated", Cards: event.Cards}
+	case core.TrendShifted:
+		return TrendShiftEvent{Type: "TrendShift", Shift: event.Shift, Trends: event.Trends}
+	case core.GameEnded:
+		return GameEndedEvent{
+			Type:         "GameEnded",
+			GameId:       event.GameId,
+			Standings:    event.Standings,
+			Awards:       event.Awards,
+			Leaderboards: event.Leaderboards,
+			TrendHistory: event.TrendHistory,
+			Deadline:     event.Deadline.UnixMilli(),
+			ServerTime:   event.ServerTime.UnixMilli(),
+		}
+	case core.RematchVoted:
+		return PlayerIdEvent{Type: "RematchVoted", PlayerId: event.PlayerId}
+	case core.ReplayFrame:
+		return ReplayFrameEvent{Type: "ReplayFrame", GameId: event.GameId, PlayerId: event.PlayerId, Event: eventMessage(*event.Replayed)}
+	case core.ReplayEnded:
+		return ReplayEndedEvent{Type: "ReplayEnded", GameId: event.GameId}
+	case core.ForecastRevealed:
+		return ForecastEvent{Type: "Forecast", Forecast: event.Forecast}
+	case core.RoomSnapshot:
+		snapshot := RoomSnapshotEvent{
+			Type:         "RoomSnapshot",
+			Room:         event.Room,
+			Turn:         event.Turn,
+			Cards:        event.Cards,
+			Phrase:       event.Phrase,
+			Trends:       event.Trends,
+			PlayersCards: event.PlayersCards,
+			Leaderboards: event.Leaderboards,
+			Standings:    event.Standings,
+			Awards:       event.Awards,
+			ServerTime:   event.ServerTime.UnixMilli(),
+		}
+		if !event.Deadline.IsZero() {
+			snapshot.Deadline = event.Deadline.UnixMilli()
 		}
+		return snapshot
 	}
+	return nil
 }
@@ -0,0 +1,69 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Code string
+
+const (
+	Internal               Code = "internal"
+	InvalidMessage         Code = "invalid_message"
+	UnsupportedVersion     Code = "unsupported_version"
+	InvalidToken           Code = "invalid_token"
+	InvalidSettings        Code = "invalid_settings"
+	PlayerNotFound         Code = "player_not_found"
+	PlayerAlreadyConnected Code = "player_already_connected"
+	RoomNotFound           Code = "room_not_found"
+	GameNotFound           Code = "game_not_found"
+	RoomFull               Code = "room_full"
+	RoomTimeout            Code = "room_timeout"
+	NotInRoom              Code = "not_in_room"
+	NotHost                Code = "not_host"
+	NotEnoughPlayers       Code = "not_enough_players"
+	WrongPhase             Code = "wrong_phase"
+	CardNotInHand          Code = "card_not_in_hand"
+	DuplicateCard          Code = "duplicate_card"
+	InvalidCardCount       Code = "invalid_card_count"
+	AlreadySubmitted       Code = "already_submitted"
+	InvalidReviewTarget    Code = "invalid_review_target"
+	InvalidBallot          Code = "invalid_ballot"
+	ActionLimitReached     Code = "action_limit_reached"
+	FeatureDisabled        Code = "feature_disabled"
+	InsufficientScore      Code = "insufficient_score"
+	InvalidCategory        Code = "invalid_category"
+	InvalidStake           Code = "invalid_stake"
+	ReplayUnavailable      Code = "replay_unavailable"
+)
+
+type Params map[string]interface{}
+
+// Error carries a stable code clients can localize, the params needed to
+// render it and a human readable message for logs and debugging.
+type Error struct {
+	Code    Code
+	Message string
+	Params  Params `json:",omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func New(code Code, params Params, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Params: params}
+}
+
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return &Error{Code: Internal, Message: err.Error()}
+}
+
+func Is(err error, code Code) bool {
+	var appErr *Error
+	return errors.As(err, &appErr) && appErr.Code == code
+}
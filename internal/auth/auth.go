@@ -1,9 +1,9 @@
 package auth
 
 import (
-	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"pitch-perfect-server/internal/apperr"
 	"time"
 )
 
@@ -30,15 +30,26 @@ func CheckToken(tokenString string) (uuid.UUID, error) {
 	})
 
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, invalidToken(err.Error())
 	}
 
 	if !token.Valid {
-		return uuid.Nil, fmt.Errorf("invalid token")
+		return uuid.Nil, invalidToken("token is not valid")
 	}
 
 	idStr, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, invalidToken(err.Error())
+	}
+
 	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, invalidToken(err.Error())
+	}
+
+	return id, nil
+}
 
-	return id, err
+func invalidToken(reason string) error {
+	return apperr.New(apperr.InvalidToken, apperr.Params{"reason": reason}, "invalid token: %s", reason)
 }
@@ -0,0 +1,255 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"pitch-perfect-server/internal/apperr"
+	"slices"
+)
+
+const (
+	ReviewModeLikes  = "likes"
+	ReviewModeRanked = "ranked"
+	ReviewModeBudget = "budget"
+	ReviewModeStars  = "stars"
+	ReviewModeBest   = "best"
+)
+
+const MaxStars = 5
+
+// Ballot holds a review in the shape required by the room review mode, only
+// the field matching the mode is expected to be set.
+type Ballot struct {
+	Likes   map[uuid.UUID]bool
+	Ranking []uuid.UUID
+	Points  map[uuid.UUID]uint
+	Stars   map[uuid.UUID]uint
+	Best    uuid.UUID
+}
+
+type ReviewMode interface {
+	validate(ballot Ballot, budget uint) error
+	targets(ballot Ballot) []uuid.UUID
+	tally(ballot Ballot, candidates int, votes map[uuid.UUID]uint)
+}
+
+var reviewModes = map[string]ReviewMode{
+	ReviewModeLikes:  likesMode{},
+	ReviewModeRanked: rankedMode{},
+	ReviewModeBudget: budgetMode{},
+	ReviewModeStars:  starsMode{},
+	ReviewModeBest:   bestMode{},
+}
+
+func GetReviewMode(name string) (ReviewMode, error) {
+	mode, ok := reviewModes[name]
+	if !ok {
+		return nil, apperr.New(apperr.InvalidSettings, apperr.Params{"field": "ReviewMode", "value": name}, "unknown review mode %s", name)
+	}
+	return mode, nil
+}
+
+func invalidBallot(mode string, reason string) error {
+	return apperr.New(apperr.InvalidBallot, apperr.Params{"mode": mode, "reason": reason}, "invalid %s ballot: %s", mode, reason)
+}
+
+func (b Ballot) fields() uint {
+	var fields uint
+	if b.Likes != nil {
+		fields += 1
+	}
+	if b.Ranking != nil {
+		fields += 1
+	}
+	if b.Points != nil {
+		fields += 1
+	}
+	if b.Stars != nil {
+		fields += 1
+	}
+	if b.Best != uuid.Nil {
+		fields += 1
+	}
+	return fields
+}
+
+// likesMode gives one vote for every liked pitch.
+type likesMode struct{}
+
+func (likesMode) validate(ballot Ballot, budget uint) error {
+	if ballot.Likes == nil || ballot.fields() != 1 {
+		return invalidBallot(ReviewModeLikes, "likes required")
+	}
+	return nil
+}
+
+func (likesMode) targets(ballot Ballot) []uuid.UUID {
+	targets := make([]uuid.UUID, 0, len(ballot.Likes))
+	for target := range ballot.Likes {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func (likesMode) tally(ballot Ballot, candidates int, votes map[uuid.UUID]uint) {
+	for target, liked := range ballot.Likes {
+		if liked {
+			votes[target] += 1
+		}
+	}
+}
+
+// rankedMode is a Borda count, the first ranked pitch gets as many votes as
+// there are pitches to review and every following one gets one less.
+type rankedMode struct{}
+
+func (rankedMode) validate(ballot Ballot, budget uint) error {
+	if len(ballot.Ranking) == 0 || ballot.fields() != 1 {
+		return invalidBallot(ReviewModeRanked, "ranking required")
+	}
+	for i, target := range ballot.Ranking {
+		if slices.Contains(ballot.Ranking[:i], target) {
+			return invalidBallot(ReviewModeRanked, "pitch ranked more than once")
+		}
+	}
+	return nil
+}
+
+func (rankedMode) targets(ballot Ballot) []uuid.UUID {
+	return ballot.Ranking
+}
+
+func (rankedMode) tally(ballot Ballot, candidates int, votes map[uuid.UUID]uint) {
+	for i, target := range ballot.Ranking {
+		if i < candidates {
+			votes[target] += uint(candidates - i)
+		}
+	}
+}
+
+// budgetMode lets every player split a fixed amount of points between the
+// other pitches.
+type budgetMode struct{}
+
+func (budgetMode) validate(ballot Ballot, budget uint) error {
+	if ballot.Points == nil || ballot.fields() != 1 {
+		return invalidBallot(ReviewModeBudget, "points required")
+	}
+	// Checked entry by entry so oversized points cannot wrap the sum around.
+	var spent uint
+	for _, points := range ballot.Points {
+		if points > budget || spent > budget-points {
+			return apperr.New(apperr.InvalidBallot, apperr.Params{"mode": ReviewModeBudget, "reason": "budget exceeded", "budget": budget}, "more than %d points spent", budget)
+		}
+		spent += points
+	}
+	return nil
+}
+
+func (budgetMode) targets(ballot Ballot) []uuid.UUID {
+	targets := make([]uuid.UUID, 0, len(ballot.Points))
+	for target := range ballot.Points {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func (budgetMode) tally(ballot Ballot, candidates int, votes map[uuid.UUID]uint) {
+	for target, points := range ballot.Points {
+		votes[target] += points
+	}
+}
+
+// starsMode rates every pitch from one to MaxStars stars.
+type starsMode struct{}
+
+func (starsMode) validate(ballot Ballot, budget uint) error {
+	if ballot.Stars == nil || ballot.fields() != 1 {
+		return invalidBallot(ReviewModeStars, "stars required")
+	}
+	for _, stars := range ballot.Stars {
+		if stars < 1 || stars > MaxStars {
+			return apperr.New(apperr.InvalidBallot, apperr.Params{"mode": ReviewModeStars, "reason": "stars out of range", "min": 1, "max": MaxStars}, "stars must be between 1 and %d", MaxStars)
+		}
+	}
+	return nil
+}
+
+func (starsMode) targets(ballot Ballot) []uuid.UUID {
+	targets := make([]uuid.UUID, 0, len(ballot.Stars))
+	for target := range ballot.Stars {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func (starsMode) tally(ballot Ballot, candidates int, votes map[uuid.UUID]uint) {
+	for target, stars := range ballot.Stars {
+		votes[target] += stars
+	}
+}
+
+// bestMode gives a single vote to the best pitch.
+type bestMode struct{}
+
+func (bestMode) validate(ballot Ballot, budget uint) error {
+	if ballot.Best == uuid.Nil || ballot.fields() != 1 {
+		return invalidBallot(ReviewModeBest, "best pitch required")
+	}
+	return nil
+}
+
+func (bestMode) targets(ballot Ballot) []uuid.UUID {
+	return []uuid.UUID{ballot.Best}
+}
+
+func (bestMode) tally(ballot Ballot, candidates int, votes map[uuid.UUID]uint) {
+	votes[ballot.Best] += 1
+}
+
+// resolve replaces the opaque handles of an anonymous review with the players
+// they stand for.
+func (b Ballot) resolve(handles map[uuid.UUID]uuid.UUID) (Ballot, error) {
+	if len(handles) == 0 {
+		return b, nil
+	}
+
+	var err error
+	player := func(handle uuid.UUID) uuid.UUID {
+		id, ok := handles[handle]
+		if !ok && err == nil {
+			err = apperr.New(apperr.InvalidReviewTarget, apperr.Params{"target": handle}, "unknown pitch %s", handle.String())
+		}
+		return id
+	}
+
+	resolved := Ballot{}
+	if b.Likes != nil {
+		resolved.Likes = make(map[uuid.UUID]bool)
+		for handle, liked := range b.Likes {
+			resolved.Likes[player(handle)] = liked
+		}
+	}
+	if b.Ranking != nil {
+		resolved.Ranking = make([]uuid.UUID, 0, len(b.Ranking))
+		for _, handle := range b.Ranking {
+			resolved.Ranking = append(resolved.Ranking, player(handle))
+		}
+	}
+	if b.Points != nil {
+		resolved.Points = make(map[uuid.UUID]uint)
+		for handle, points := range b.Points {
+			resolved.Points[player(handle)] = points
+		}
+	}
+	if b.Stars != nil {
+		resolved.Stars = make(map[uuid.UUID]uint)
+		for handle, stars := range b.Stars {
+			resolved.Stars[player(handle)] = stars
+		}
+	}
+	if b.Best != uuid.Nil {
+		resolved.Best = player(b.Best)
+	}
+
+	return resolved, err
+}
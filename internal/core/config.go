@@ -8,8 +8,14 @@ import (
 	"pitch-perfect-server/internal/entities"
 )
 
+// ConfigVersion is the game configuration the server loads, replays record
+// it so they can be matched with the words and phrases they refer to.
+const ConfigVersion = "1"
+
+var ConfigPath = "./config/" + ConfigVersion + "/game_configuration.json"
+
 func InitConfig() error {
-	bytes, err := os.ReadFile("./config/1/game_configuration.json")
+	bytes, err := os.ReadFile(ConfigPath)
 	if err != nil {
 		return err
 	}
@@ -20,6 +26,26 @@ func InitConfig() error {
 	}
 	dataMap := data.(map[string]interface{})
 
+	var trends struct {
+		Trends *TrendConfig  `json:"trends"`
+		Shocks []ShockConfig `json:"shocks"`
+	}
+	if err := json.Unmarshal(bytes, &trends); err != nil {
+		return err
+	}
+	if trends.Trends != nil {
+		if err := trends.Trends.validate(); err != nil {
+			return err
+		}
+		Trends = *trends.Trends
+	}
+	if trends.Shocks != nil {
+		if err := validateShocks(trends.Shocks); err != nil {
+			return err
+		}
+		Shocks = trends.Shocks
+	}
+
 	phrases := dataMap["phrases"].([]interface{})
 	iter.ForEach(phrases,
 		func(subDataPtr *interface{}) {
@@ -52,7 +78,15 @@ func InitConfig() error {
 			database.Db.Save(&entity)
 		})
 
-	return nil
+	loadedCategories, err := GetCategories()
+	if err != nil {
+		return err
+	}
+	loadedWords, err := GetWords()
+	if err != nil {
+		return err
+	}
+	return validateCategories(loadedCategories, loadedWords)
 }
 
 func GetPhrases() ([]entities.Phrase, error) {
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/sourcegraph/conc/iter"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+	"sync"
+	"sync/atomic"
+)
+
+var playersIndex map[uuid.UUID]map[uuid.UUID]*Connection
+var playersMutex sync.Mutex
+
+const (
+	ConnectionPolicyAllowMany uint = iota
+	ConnectionPolicyNewestWins
+	ConnectionPolicyRejectNew
+)
+
+const (
+	SlowConsumerDisconnect uint = iota
+	SlowConsumerDropOldest
+)
+
+var ConnectionPolicy = ConnectionPolicyAllowMany
+var SlowConsumerPolicy = SlowConsumerDisconnect
+var QueueSize = 64
+
+type Connection struct {
+	Id       uuid.UUID
+	PlayerId uuid.UUID
+	Events   chan PlayerEvent
+	Done     chan struct{}
+	once     sync.Once
+	sent     atomic.Uint64
+	dropped  atomic.Uint64
+	maxDepth atomic.Int64
+}
+
+type ConnectionStats struct {
+	Id       uuid.UUID
+	PlayerId uuid.UUID
+	Depth    int
+	Capacity int
+	MaxDepth int64
+	Sent     uint64
+	Dropped  uint64
+}
+
+func (c *Connection) Close() {
+	c.once.Do(func() {
+		close(c.Done)
+	})
+}
+
+func (c *Connection) send(event PlayerEvent) {
+	select {
+	case <-c.Done:
+		return
+	default:
+	}
+
+	for {
+		select {
+		case c.Events <- event:
+			c.sent.Add(1)
+			c.updateMaxDepth()
+			return
+		default:
+		}
+
+		c.dropped.Add(1)
+		if SlowConsumerPolicy == SlowConsumerDisconnect {
+			log.Warn().Str("player", c.PlayerId.String()).Msg("Disconnecting slow consumer")
+			c.Close()
+			return
+		}
+
+		select {
+		case <-c.Events:
+		default:
+		}
+	}
+}
+
+func (c *Connection) updateMaxDepth() {
+	depth := int64(len(c.Events))
+	for {
+		maxDepth := c.maxDepth.Load()
+		if depth <= maxDepth || c.maxDepth.CompareAndSwap(maxDepth, depth) {
+			return
+		}
+	}
+}
+
+func (c *Connection) Stats() ConnectionStats {
+	return ConnectionStats{
+		Id:       c.Id,
+		PlayerId: c.PlayerId,
+		Depth:    len(c.Events),
+		Capacity: cap(c.Events),
+		MaxDepth: c.maxDepth.Load(),
+		Sent:     c.sent.Load(),
+		Dropped:  c.dropped.Load(),
+	}
+}
+
+func ParseConnectionPolicy(policy string) (uint, error) {
+	switch policy {
+	case "allow_many":
+		return ConnectionPolicyAllowMany, nil
+	case "newest_wins":
+		return ConnectionPolicyNewestWins, nil
+	case "reject_new":
+		return ConnectionPolicyRejectNew, nil
+	}
+	return 0, fmt.Errorf("unknown connection policy %s", policy)
+}
+
+func ParseSlowConsumerPolicy(policy string) (uint, error) {
+	switch policy {
+	case "disconnect":
+		return SlowConsumerDisconnect, nil
+	case "drop_oldest":
+		return SlowConsumerDropOldest, nil
+	}
+	return 0, fmt.Errorf("unknown slow consumer policy %s", policy)
+}
+
+func AddPlayerConnection(playerId uuid.UUID) (*Connection, error) {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+	if playersIndex == nil {
+		playersIndex = make(map[uuid.UUID]map[uuid.UUID]*Connection)
+	}
+
+	connections, ok := playersIndex[playerId]
+	if !ok {
+		connections = make(map[uuid.UUID]*Connection)
+		playersIndex[playerId] = connections
+	}
+
+	if len(connections) > 0 {
+		switch ConnectionPolicy {
+		case ConnectionPolicyRejectNew:
+			return nil, apperr.New(apperr.PlayerAlreadyConnected, apperr.Params{"playerId": playerId}, "player %s is already connected", playerId.String())
+		case ConnectionPolicyNewestWins:
+			for id, connection := range connections {
+				connection.Close()
+				delete(connections, id)
+			}
+			break
+		}
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &Connection{
+		Id:       id,
+		PlayerId: playerId,
+		Events:   make(chan PlayerEvent, QueueSize),
+		Done:     make(chan struct{}),
+	}
+	connections[id] = connection
+
+	return connection, nil
+}
+
+func RemovePlayerConnection(connection *Connection) bool {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+	connection.Close()
+
+	connections, ok := playersIndex[connection.PlayerId]
+	if !ok {
+		return false
+	}
+
+	_, ok = connections[connection.Id]
+	if !ok {
+		return false
+	}
+
+	delete(connections, connection.Id)
+	if len(connections) > 0 {
+		return false
+	}
+
+	delete(playersIndex, connection.PlayerId)
+	return true
+}
+
+func GetConnectionsStats() []ConnectionStats {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+	stats := make([]ConnectionStats, 0)
+	for _, connections := range playersIndex {
+		for _, connection := range connections {
+			stats = append(stats, connection.Stats())
+		}
+	}
+	return stats
+}
+
+func sendToPlayer(playerId uuid.UUID, event PlayerEvent) {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+	for _, connection := range playersIndex[playerId] {
+		connection.send(event)
+	}
+}
+
+func sendToConnection(playerId uuid.UUID, connectionId uuid.UUID, event PlayerEvent) {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+	connection, ok := playersIndex[playerId][connectionId]
+	if ok {
+		connection.send(event)
+	}
+}
+
+func sendToRoom(room *entities.Room, event PlayerEvent) {
+	iter.ForEach(room.Players,
+		func(player *entities.Player) {
+			sendToPlayer(player.ID, event)
+		})
+}
+
+func SendToAllConnectedPlayers(event PlayerEvent) {
+	playersMutex.Lock()
+	defer playersMutex.Unlock()
+	for _, connections := range playersIndex {
+		for _, connection := range connections {
+			connection.send(event)
+		}
+	}
+}
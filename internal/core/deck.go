@@ -0,0 +1,81 @@
+package core
+
+import "math/rand"
+
+// Deck draws from a shuffled pile and, once it runs out, reshuffles the
+// discarded cards back into it. Decks built without recycling never hand
+// out a discarded card again.
+type Deck[T any] struct {
+	draw    []T
+	discard []T
+	recycle bool
+	random  *rand.Rand
+}
+
+func NewDeck[T any](cards []T, recycle bool, random *rand.Rand) *Deck[T] {
+	deck := &Deck[T]{draw: cards, discard: make([]T, 0), recycle: recycle, random: random}
+	deck.shuffle()
+	return deck
+}
+
+func (d *Deck[T]) shuffle() {
+	shuffleDeck(d.random, &d.draw)
+}
+
+func (d *Deck[T]) Draw() (T, bool) {
+	if len(d.draw) == 0 {
+		d.Reshuffle()
+	}
+
+	var card T
+	if len(d.draw) == 0 {
+		return card, false
+	}
+
+	card = d.draw[0]
+	d.draw = d.draw[1:]
+	return card, true
+}
+
+func (d *Deck[T]) Discard(cards ...T) {
+	d.discard = append(d.discard, cards...)
+}
+
+func (d *Deck[T]) Reshuffle() {
+	if !d.recycle || len(d.discard) == 0 {
+		return
+	}
+
+	d.draw = append(d.draw, d.discard...)
+	d.discard = make([]T, 0)
+	d.shuffle()
+}
+
+func (d *Deck[T]) Remaining() int {
+	return len(d.draw)
+}
+
+func (d *Deck[T]) Discarded() int {
+	return len(d.discard)
+}
+
+// DeckState is the serialisable content of a deck, in draw order.
+type DeckState[T any] struct {
+	Draw    []T
+	Discard []T
+	Recycle bool
+}
+
+func (d *Deck[T]) state() *DeckState[T] {
+	if d == nil {
+		return nil
+	}
+	return &DeckState[T]{Draw: d.draw, Discard: d.discard, Recycle: d.recycle}
+}
+
+func restoreDeck[T any](state *DeckState[T], random *rand.Rand) *Deck[T] {
+	if state == nil {
+		return nil
+	}
+	return &Deck[T]{draw: state.Draw, discard: state.Discard, recycle: state.Recycle, random: random}
+}
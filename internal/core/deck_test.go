@@ -0,0 +1,103 @@
+package core
+
+import (
+	"math/rand"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"testing"
+)
+
+func newTestRandom() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+func TestDeckDrainsWithoutRecycling(t *testing.T) {
+	deck := NewDeck([]uint{1, 2, 3, 4, 5}, false, newTestRandom())
+
+	drawn := make([]uint, 0)
+	for i := 0; i < 5; i++ {
+		card, ok := deck.Draw()
+		if !ok {
+			t.Fatalf("deck empty after %d draws", i)
+		}
+		drawn = append(drawn, card)
+		deck.Discard(card)
+	}
+	slices.Sort(drawn)
+	if !slices.Equal(drawn, []uint{1, 2, 3, 4, 5}) {
+		t.Fatalf("drew %v, expected every card once", drawn)
+	}
+
+	if card, ok := deck.Draw(); ok {
+		t.Fatalf("drew %d from a drained deck", card)
+	}
+	if deck.Remaining() != 0 || deck.Discarded() != 5 {
+		t.Fatalf("%d remaining and %d discarded, expected 0 and 5", deck.Remaining(), deck.Discarded())
+	}
+}
+
+func TestDeckReshufflesDiscardPile(t *testing.T) {
+	deck := NewDeck([]uint{1, 2, 3}, true, newTestRandom())
+
+	for i := 0; i < 3; i++ {
+		card, _ := deck.Draw()
+		deck.Discard(card)
+	}
+	if deck.Remaining() != 0 || deck.Discarded() != 3 {
+		t.Fatalf("%d remaining and %d discarded, expected 0 and 3", deck.Remaining(), deck.Discarded())
+	}
+
+	card, ok := deck.Draw()
+	if !ok {
+		t.Fatal("deck did not reshuffle its discard pile")
+	}
+	if !slices.Contains([]uint{1, 2, 3}, card) {
+		t.Fatalf("drew unknown card %d", card)
+	}
+	if deck.Remaining() != 2 || deck.Discarded() != 0 {
+		t.Fatalf("%d remaining and %d discarded, expected 2 and 0", deck.Remaining(), deck.Discarded())
+	}
+}
+
+func TestDeckDoesNotReshuffleCardsInHand(t *testing.T) {
+	deck := NewDeck([]uint{1, 2}, true, newTestRandom())
+
+	held, _ := deck.Draw()
+	discarded, _ := deck.Draw()
+	deck.Discard(discarded)
+
+	card, ok := deck.Draw()
+	if !ok || card != discarded {
+		t.Fatalf("drew %d, expected the discarded %d", card, discarded)
+	}
+	if card, ok := deck.Draw(); ok {
+		t.Fatalf("drew %d while %d is still held", card, held)
+	}
+}
+
+func TestNoPhraseRepeatsAcrossTurns(t *testing.T) {
+	phrases, err := GetPhrases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := withDefaultSettings(entities.RoomSettings{Turns: uint(len(phrases)), NoPhraseRepeats: true})
+	if err := ValidateRoomSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	room := entities.Room{Settings: settings}
+	game := NewGame()
+	game.gameStart(&room, newPhaseTimer(RoomClock, make(chan RoomCmd)))
+
+	seen := make(map[uint]bool)
+	for turn := uint(1); turn <= settings.Turns; turn++ {
+		game.generatePhrase()
+		if seen[game.phrase.ID] {
+			t.Fatalf("phrase %d repeated on turn %d", game.phrase.ID, turn)
+		}
+		seen[game.phrase.ID] = true
+	}
+	if game.phrases.Remaining() != 0 {
+		t.Fatalf("%d phrases left after %d turns", game.phrases.Remaining(), settings.Turns)
+	}
+}
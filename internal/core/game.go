@@ -0,0 +1,466 @@
+package core
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/sourcegraph/conc/iter"
+	"maps"
+	"math/rand"
+	database "pitch-perfect-server/internal/db"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"time"
+)
+
+type Game struct {
+	id            uuid.UUID
+	words         *Deck[entities.Word]
+	phrases       *Deck[entities.Phrase]
+	phrase        entities.Phrase
+	hands         map[uuid.UUID][]entities.Word
+	trends        map[uint]uint
+	selectedCards map[uuid.UUID][]uint
+	playersReview map[uuid.UUID]Ballot
+	submittedAt   map[uuid.UUID]time.Time
+	shifts        []TrendShift
+	shiftsAt      map[uuid.UUID]int
+	handles       map[uuid.UUID]uuid.UUID
+	mulligans     map[uuid.UUID]bool
+	swaps         map[uuid.UUID]uint
+	penalties     map[uuid.UUID]uint
+	bets          map[uuid.UUID]Bet
+	forecasts     map[uuid.UUID]bool
+	votesReceived map[uuid.UUID]uint
+	bestTurn      map[uuid.UUID]uint
+	wins          map[uuid.UUID]uint
+	rematch       map[uuid.UUID]bool
+	turn          uint
+	leaderboard   map[uuid.UUID]uint
+	trendEngine   *TrendEngine
+	shocks        []ShockConfig
+	trendHistory  []map[uint]uint
+	seed          int64
+	step          uint64
+	random        *rand.Rand
+	out           *roomOutbox
+}
+
+func NewGame() *Game {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &Game{
+		hands:         make(map[uuid.UUID][]entities.Word),
+		selectedCards: make(map[uuid.UUID][]uint),
+		playersReview: make(map[uuid.UUID]Ballot),
+		submittedAt:   make(map[uuid.UUID]time.Time),
+		shiftsAt:      make(map[uuid.UUID]int),
+		handles:       make(map[uuid.UUID]uuid.UUID),
+		mulligans:     make(map[uuid.UUID]bool),
+		swaps:         make(map[uuid.UUID]uint),
+		penalties:     make(map[uuid.UUID]uint),
+		bets:          make(map[uuid.UUID]Bet),
+		forecasts:     make(map[uuid.UUID]bool),
+		votesReceived: make(map[uuid.UUID]uint),
+		bestTurn:      make(map[uuid.UUID]uint),
+		wins:          make(map[uuid.UUID]uint),
+		rematch:       make(map[uuid.UUID]bool),
+		leaderboard:   make(map[uuid.UUID]uint),
+		trendEngine:   NewTrendEngine(Trends, random),
+		shocks:        Shocks,
+		trendHistory:  make([]map[uint]uint, 0),
+		random:        random,
+		out:           &roomOutbox{},
+	}
+}
+
+// reset starts a new game, keeping the random source and the outbox the room
+// goroutine handed to the previous one.
+func (g *Game) reset() {
+	random, out := g.random, g.out
+	*g = *NewGame()
+	g.random = random
+	g.out = out
+	g.trendEngine = NewTrendEngine(Trends, random)
+}
+
+// commandSeed is the seed the random source is reset to before handling a
+// command. During a game it is derived from the game seed and the number of
+// commands logged since it started, so a seed and the same commands always
+// play the same game.
+func (g *Game) commandSeed(now time.Time) int64 {
+	if g.seed == 0 {
+		return now.UnixNano()
+	}
+	return mixSeed(g.seed, g.step+1)
+}
+
+// mixSeed is the splitmix64 finalizer, it spreads consecutive steps over
+// unrelated seeds.
+func mixSeed(seed int64, step uint64) int64 {
+	z := uint64(seed) + step*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// startSeed picks the id and the seed of a new game and resets the random
+// source to the seed, rooms with a fixed seed replay the same draws every
+// game. Both are public once the game ended, so they are drawn from
+// crypto/rand rather than from anything a player could guess or derive from
+// a previous game, and a replay reads them back from the game record.
+func (g *Game) startSeed(room *entities.Room) {
+	if g.out.replaying {
+		g.id, g.seed = recordedGame(room.ID, g.out.seq+1)
+	} else {
+		g.id = uuid.New()
+		g.seed = room.Settings.Seed
+		for g.seed == 0 {
+			g.seed = cryptoSeed()
+		}
+	}
+	g.step = 0
+	g.random.Seed(g.seed)
+}
+
+func cryptoSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		log.Error().Err(err).Msg("Impossible to read a random seed")
+		return time.Now().UnixNano()
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1)
+}
+
+func (g *Game) newUUID() uuid.UUID {
+	id, err := uuid.NewRandomFromReader(g.random)
+	if err != nil {
+		return uuid.New()
+	}
+	return id
+}
+
+func shuffleDeck[T any](random *rand.Rand, deck *[]T) {
+	random.Shuffle(len(*deck), func(i, j int) { (*deck)[i], (*deck)[j] = (*deck)[j], (*deck)[i] })
+}
+
+func (g *Game) generateTrends() {
+	if g.trends == nil {
+		categories, _ := GetCategories()
+		g.trends = g.trendEngine.Initial(categories)
+	} else {
+		g.trends = g.trendEngine.Next(g.trends)
+	}
+	g.trendHistory = append(g.trendHistory, maps.Clone(g.trends))
+}
+
+func generateWordCategory() map[uint]uint {
+	words, _ := GetWords()
+	wordsCategory := make(map[uint]uint)
+	for _, v := range words {
+		wordsCategory[v.ID] = v.CategoryId
+	}
+	return wordsCategory
+}
+
+func (g *Game) generateHands(room *entities.Room) {
+	for _, v := range room.Players {
+		hand, ok := g.hands[v.ID]
+		if !ok || hand == nil {
+			hand = make([]entities.Word, 0)
+		}
+
+		missingCard := int(room.Settings.HandSize) - len(hand)
+		for i := 0; i < missingCard; i++ {
+			word, ok := g.words.Draw()
+			if !ok {
+				log.Warn().Str("room", room.ID.String()).Msg("Words deck exhausted, dealing a short hand")
+				break
+			}
+			hand = append(hand, word)
+		}
+
+		g.hands[v.ID] = hand
+	}
+}
+
+func (g *Game) removeUsedCards(playerId uuid.UUID, cards []uint) {
+	playerHand := g.hands[playerId]
+	newPlayerHand := make([]entities.Word, 0)
+	for _, w := range playerHand {
+		found := false
+		for _, c := range cards {
+			found = found || c == w.ID
+		}
+
+		if !found {
+			newPlayerHand = append(newPlayerHand, w)
+		} else {
+			g.words.Discard(w)
+		}
+	}
+	g.hands[playerId] = newPlayerHand
+}
+
+func (g *Game) generatePhrase() {
+	if g.phrase.ID != 0 {
+		g.phrases.Discard(g.phrase)
+	}
+
+	phrase, ok := g.phrases.Draw()
+	if !ok {
+		log.Error().Msg("Phrases deck exhausted, repeating the last phrase")
+		return
+	}
+	g.phrase = phrase
+}
+
+func (g *Game) resetInternal() {
+	g.selectedCards = make(map[uuid.UUID][]uint)
+	g.playersReview = make(map[uuid.UUID]Ballot)
+	g.submittedAt = make(map[uuid.UUID]time.Time)
+	g.shifts = nil
+	g.shiftsAt = make(map[uuid.UUID]int)
+	g.handles = make(map[uuid.UUID]uuid.UUID)
+	g.swaps = make(map[uuid.UUID]uint)
+	g.penalties = make(map[uuid.UUID]uint)
+	g.bets = make(map[uuid.UUID]Bet)
+	g.forecasts = make(map[uuid.UUID]bool)
+}
+
+func (g *Game) assignHandles() {
+	players := make([]uuid.UUID, 0, len(g.selectedCards))
+	for player := range g.selectedCards {
+		players = append(players, player)
+	}
+	slices.SortFunc(players, func(a uuid.UUID, b uuid.UUID) int { return slices.Compare(a[:], b[:]) })
+	shuffleDeck(g.random, &players)
+
+	for _, player := range players {
+		g.handles[g.newUUID()] = player
+	}
+}
+
+// reviewCards returns the submitted cards keyed by their opaque handle when
+// the review is anonymous, by player otherwise.
+func (g *Game) reviewCards() map[uuid.UUID][]uint {
+	if len(g.handles) == 0 {
+		return maps.Clone(g.selectedCards)
+	}
+
+	cards := make(map[uuid.UUID][]uint)
+	for handle, player := range g.handles {
+		cards[handle] = g.selectedCards[player]
+	}
+	return cards
+}
+
+func (g *Game) countVotes(room *entities.Room) map[uuid.UUID]uint {
+	reviewCount := make(map[uuid.UUID]uint)
+	for _, p := range room.Players {
+		reviewCount[p.ID] = 0
+	}
+
+	mode, err := GetReviewMode(room.Settings.ReviewMode)
+	if err != nil {
+		log.Error().Err(err).Msg("Falling back to likes review mode")
+		mode = reviewModes[ReviewModeLikes]
+	}
+
+	for p, ballot := range g.playersReview {
+		candidates := len(g.selectedCards)
+		if _, ok := g.selectedCards[p]; ok {
+			candidates -= 1
+		}
+		mode.tally(ballot, candidates, reviewCount)
+	}
+
+	return reviewCount
+}
+
+// shiftedTrends applies shifts on top of the turn trends. The turn trends
+// themselves never move during a turn, the next ones are rolled from them.
+func (g *Game) shiftedTrends(shifts []TrendShift) map[uint]uint {
+	levels := int(g.trendEngine.config.Levels)
+	trends := maps.Clone(g.trends)
+	for _, shift := range shifts {
+		level := int(trends[shift.Category]) + shift.Delta
+		trends[shift.Category] = uint(max(0, min(level, levels-1)))
+	}
+	return trends
+}
+
+// playerTrends applies on top of the turn trends the breaking news each
+// player had seen when submitting, players who saw none score on the turn
+// trends alone.
+func (g *Game) playerTrends() map[uuid.UUID]map[uint]uint {
+	playerTrends := make(map[uuid.UUID]map[uint]uint)
+	for player, seen := range g.shiftsAt {
+		if seen > 0 {
+			playerTrends[player] = g.shiftedTrends(g.shifts[:seen])
+		}
+	}
+	return playerTrends
+}
+
+func (g *Game) reviewOutcome(room *entities.Room, votes map[uuid.UUID]uint, wordCategory map[uint]uint, playerTrends map[uuid.UUID]map[uint]uint) ReviewOutcome {
+	trendScores := make(map[uuid.UUID]uint)
+	for player, cards := range g.selectedCards {
+		trends := g.trends
+		if submitted, ok := playerTrends[player]; ok {
+			trends = submitted
+		}
+		for _, card := range cards {
+			trendScores[player] += trends[wordCategory[card]] + 1
+		}
+	}
+
+	ranking := reviewRanking{votes: votes, submittedAt: g.submittedAt, trendScores: trendScores}
+	return ranking.outcome(room.Settings.TiePolicy, room.Settings.Winners, room.Settings.WinnerMultiplier)
+}
+
+func (g *Game) gameStart(room *entities.Room, timer *phaseTimer) {
+	room.State += 1
+	database.Db.Save(&room)
+	g.reset()
+	g.startSeed(room)
+	words, _ := GetWords()
+	phrases, _ := GetPhrases()
+	g.words = NewDeck(words, true, g.random)
+	g.phrases = NewDeck(phrases, !room.Settings.NoPhraseRepeats, g.random)
+	g.generateTrends()
+	g.recordGameStart(room)
+	serverTime := timer.clock.Now()
+	g.out.toRoom(room, PlayerEvent{Type: GameStarted, Trends: maps.Clone(g.trends), Deadline: timer.deadline, ServerTime: serverTime})
+}
+
+func (g *Game) startTurn(room *entities.Room, timer *phaseTimer) {
+	g.generatePhrase()
+	g.generateHands(room)
+	g.resetInternal()
+	room.State = RoomStateTurnStarted
+	database.Db.Save(&room)
+	serverTime := timer.clock.Now()
+	iter.ForEach(room.Players,
+		func(player *entities.Player) {
+			hand, ok := g.hands[player.ID]
+			if !ok {
+				log.Error().Msg("Impossible to get player hand in state")
+				return
+			}
+
+			g.out.toPlayer(player.ID, PlayerEvent{Type: TurnStarted, Cards: hand, Phrase: g.phrase, Deadline: timer.deadline, ServerTime: serverTime})
+		})
+
+	if room.Settings.Shocks > 0 {
+		for _, delay := range g.trendEngine.ShockDelays(room.Settings.Shocks, seconds(room.Settings.SelectionTimeout)) {
+			timer.schedule(RoomCmd{Type: TrendShock}, delay)
+		}
+	}
+}
+
+func (g *Game) trendShock(room *entities.Room) {
+	shift := g.trendEngine.Shock(g.shiftedTrends(g.shifts), g.shocks, room.Settings.ShockMagnitude)
+	g.shifts = append(g.shifts, shift)
+	g.out.toRoom(room, PlayerEvent{Type: TrendShifted, Shift: shift, Trends: g.shiftedTrends(g.shifts)})
+}
+
+func (g *Game) allPlayerSelectedCards(room *entities.Room, timer *phaseTimer) {
+	room.State += 1
+	database.Db.Save(&room)
+	if room.Settings.AnonymousReview {
+		g.assignHandles()
+	}
+	serverTime := timer.clock.Now()
+	g.out.toRoom(room, PlayerEvent{Type: AllPlayerSelectedCards, PlayersCards: g.reviewCards(), Deadline: timer.deadline, ServerTime: serverTime})
+}
+
+func (g *Game) endTurn(room *entities.Room) bool {
+	room.State = 0
+	database.Db.Save(&room)
+
+	previousTrends := maps.Clone(g.trends)
+	g.generateTrends()
+	votes := g.countVotes(room)
+	wordCategory := generateWordCategory()
+	playerTrends := g.playerTrends()
+	outcome := g.reviewOutcome(room, votes, wordCategory, playerTrends)
+
+	scorer, err := GetScorer(room.Settings.Scoring)
+	if err != nil {
+		log.Error().Err(err).Msg("Falling back to the default scoring rules")
+		scorer = scorers[DefaultScorer]
+	}
+
+	breakdown := scorer.Score(ScoreInput{
+		Trends:       g.trends,
+		PlayerTrends: playerTrends,
+		WordCategory: wordCategory,
+		Cards:        g.selectedCards,
+		Votes:        votes,
+		Multipliers:  outcome.multipliers(),
+	})
+
+	g.applyPenalties(breakdown)
+	g.settleMarket(previousTrends, room.Settings, breakdown)
+
+	turnLeaderboard := make(map[uuid.UUID]uint)
+	for player, score := range breakdown {
+		turnLeaderboard[player] = score.Total
+	}
+
+	for _, player := range room.Players {
+		balance := int(g.leaderboard[player.ID]) + breakdown[player.ID].Balance
+		g.leaderboard[player.ID] = uint(max(balance, 0))
+	}
+
+	g.recordStats(votes, turnLeaderboard, outcome)
+	g.recordTurn(votes, turnLeaderboard)
+
+	g.turn += 1
+	GameEnded := g.turn >= room.Settings.Turns
+
+	g.out.toRoom(room, PlayerEvent{Type: TurnEnded, Trends: maps.Clone(g.trends), Leaderboards: maps.Clone(g.leaderboard), Result: turnLeaderboard, Breakdown: breakdown, Votes: votes, Outcome: outcome, Handles: maps.Clone(g.handles), TrendHistory: slices.Clone(g.trendHistory), LastTurn: GameEnded})
+
+	return GameEnded
+}
+
+func (g *Game) snapshot(playerId uuid.UUID, room *entities.Room, timer *phaseTimer) PlayerEvent {
+	trends := maps.Clone(g.trends)
+	if room.State == RoomStateTurnStarted || room.State == RoomStateReview {
+		trends = g.shiftedTrends(g.shifts)
+	}
+	event := PlayerEvent{
+		Type:         RoomSnapshot,
+		Room:         *room,
+		Trends:       trends,
+		Leaderboards: maps.Clone(g.leaderboard),
+		Turn:         g.turn,
+		Deadline:     timer.deadline,
+		ServerTime:   timer.clock.Now(),
+	}
+
+	if room.State == RoomStateWaiting {
+		return event
+	}
+
+	event.Cards = slices.Clone(g.hands[playerId])
+	event.Phrase = g.phrase
+	switch room.State {
+	case RoomStateTurnStarted:
+		cards, ok := g.selectedCards[playerId]
+		if ok {
+			event.PlayersCards = map[uuid.UUID][]uint{playerId: cards}
+		}
+		break
+	case RoomStateReview:
+		event.PlayersCards = g.reviewCards()
+		break
+	case RoomStateGameOver:
+		event.Standings = g.standings(room)
+		event.Awards = g.awards()
+		break
+	}
+
+	return event
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"maps"
+	database "pitch-perfect-server/internal/db"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+)
+
+const (
+	AwardMostVotes = "most_votes"
+	AwardBestTurn  = "best_turn"
+	AwardMostWins  = "most_wins"
+)
+
+type Standing struct {
+	PlayerId uuid.UUID
+	Rank     uint
+	Score    uint
+}
+
+type Award struct {
+	Name    string
+	Players []uuid.UUID
+	Value   uint
+}
+
+// recordStats keeps the per game figures the awards are computed from.
+func (g *Game) recordStats(votes map[uuid.UUID]uint, result map[uuid.UUID]uint, outcome ReviewOutcome) {
+	for player, count := range votes {
+		g.votesReceived[player] += count
+	}
+	for player, points := range result {
+		g.bestTurn[player] = max(g.bestTurn[player], points)
+	}
+	for _, winner := range outcome.Winners {
+		if winner.Place == 1 {
+			g.wins[winner.PlayerId] += 1
+		}
+	}
+}
+
+// standings ranks players by final score, players with the same score share
+// the same rank.
+func (g *Game) standings(room *entities.Room) []Standing {
+	players := make([]uuid.UUID, 0, len(room.Players))
+	for _, player := range room.Players {
+		players = append(players, player.ID)
+	}
+	slices.SortFunc(players, func(a uuid.UUID, b uuid.UUID) int {
+		if g.leaderboard[a] != g.leaderboard[b] {
+			if g.leaderboard[a] > g.leaderboard[b] {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a[:], b[:])
+	})
+
+	standings := make([]Standing, 0, len(players))
+	for i, player := range players {
+		rank := uint(i + 1)
+		if i > 0 && g.leaderboard[player] == standings[i-1].Score {
+			rank = standings[i-1].Rank
+		}
+		standings = append(standings, Standing{PlayerId: player, Rank: rank, Score: g.leaderboard[player]})
+	}
+	return standings
+}
+
+func (g *Game) awards() []Award {
+	awards := make([]Award, 0)
+	for _, stat := range []struct {
+		name   string
+		values map[uuid.UUID]uint
+	}{
+		{AwardMostVotes, g.votesReceived},
+		{AwardBestTurn, g.bestTurn},
+		{AwardMostWins, g.wins},
+	} {
+		if award, ok := bestOf(stat.name, stat.values); ok {
+			awards = append(awards, award)
+		}
+	}
+	return awards
+}
+
+func bestOf(name string, values map[uuid.UUID]uint) (Award, bool) {
+	award := Award{Name: name, Players: make([]uuid.UUID, 0)}
+	for player, value := range values {
+		if value > award.Value {
+			award.Value = value
+			award.Players = award.Players[:0]
+		}
+		if value == award.Value && value > 0 {
+			award.Players = append(award.Players, player)
+		}
+	}
+	slices.SortFunc(award.Players, func(a uuid.UUID, b uuid.UUID) int { return slices.Compare(a[:], b[:]) })
+	return award, award.Value > 0
+}
+
+func (g *Game) gameOver(room *entities.Room, timer *phaseTimer) {
+	timer.start(RoomCmd{Type: RematchTimeout}, seconds(room.Settings.RematchTimeout))
+	room.State = RoomStateGameOver
+	room.PlayersReady = nil
+	database.Db.Save(room)
+
+	g.rematch = make(map[uuid.UUID]bool)
+	standings := g.standings(room)
+	g.recordGameEnd(standings, timer.clock.Now())
+	g.out.toRoom(room, PlayerEvent{
+		Type:         GameEnded,
+		GameId:       g.id,
+		Standings:    standings,
+		Awards:       g.awards(),
+		Leaderboards: maps.Clone(g.leaderboard),
+		TrendHistory: slices.Clone(g.trendHistory),
+		Deadline:     timer.deadline,
+		ServerTime:   timer.clock.Now(),
+	})
+}
+
+func (g *Game) handleCmdDuringGameOver(cmd RoomCmd, room *entities.Room, timer *phaseTimer) error {
+	switch cmd.Type {
+	case Rematch:
+		g.rematch[cmd.PlayerId] = true
+		g.out.toRoom(room, PlayerEvent{Type: RematchVoted, PlayerId: cmd.PlayerId})
+		if g.rematchAgreed(room) {
+			g.startRematch(room, timer)
+		}
+		break
+	case RematchTimeout:
+		g.startRematch(room, timer)
+		break
+	default:
+		return wrongPhase(cmd, room)
+	}
+	return nil
+}
+
+// rematchAgreed reports whether everyone still in the room voted for a
+// rematch.
+func (g *Game) rematchAgreed(room *entities.Room) bool {
+	for _, player := range room.Players {
+		if !g.rematch[player.ID] {
+			return false
+		}
+	}
+	return len(room.Players) > 0
+}
+
+// startRematch sends the players who did not opt in back to the lobby and
+// starts a fresh game for the others, or waits for more players when too few
+// are left.
+func (g *Game) startRematch(room *entities.Room, timer *phaseTimer) {
+	// Left first, so the leaves below do not start the rematch again.
+	timer.stop()
+	room.State = RoomStateWaiting
+	for _, player := range slices.Clone(room.Players) {
+		if g.rematch[player.ID] {
+			continue
+		}
+		if !g.out.replaying {
+			if err := removePlayerFromRoom(player.ID); err != nil {
+				log.Error().Err(err).Msg("Impossible to move the player back to the lobby")
+			}
+		}
+		g.leaveRoomCycle(room, player.ID, timer)
+		g.out.toPlayer(player.ID, PlayerEvent{Type: RoomLeaved, PlayerId: player.ID})
+	}
+
+	room.PlayersReady = nil
+	database.Db.Save(room)
+
+	if len(room.Players) > 0 && uint(len(room.Players)) >= room.Settings.MinPlayers {
+		g.startGame(room, timer)
+		return
+	}
+	g.out.toRoom(room, PlayerEvent{Type: RoomUpdated, Room: *room})
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"pitch-perfect-server/internal/entities"
+	"time"
+)
+
+// gameState mirrors Game with exported fields so a room checkpoint can store
+// it. The random source and the outbox belong to the room goroutine and are
+// not part of it.
+type gameState struct {
+	Id            uuid.UUID
+	Words         *DeckState[entities.Word]
+	Phrases       *DeckState[entities.Phrase]
+	Phrase        entities.Phrase
+	Hands         map[uuid.UUID][]entities.Word
+	Trends        map[uint]uint
+	SelectedCards map[uuid.UUID][]uint
+	PlayersReview map[uuid.UUID]Ballot
+	SubmittedAt   map[uuid.UUID]time.Time
+	Shifts        []TrendShift
+	ShiftsAt      map[uuid.UUID]int
+	Handles       map[uuid.UUID]uuid.UUID
+	Mulligans     map[uuid.UUID]bool
+	Swaps         map[uuid.UUID]uint
+	Penalties     map[uuid.UUID]uint
+	Bets          map[uuid.UUID]Bet
+	Forecasts     map[uuid.UUID]bool
+	VotesReceived map[uuid.UUID]uint
+	BestTurn      map[uuid.UUID]uint
+	Wins          map[uuid.UUID]uint
+	Rematch       map[uuid.UUID]bool
+	Turn          uint
+	Leaderboard   map[uuid.UUID]uint
+	TrendConfig   TrendConfig
+	ShockConfig   []ShockConfig
+	TrendHistory  []map[uint]uint
+	Seed          int64
+	Step          uint64
+}
+
+func (g *Game) state() gameState {
+	return gameState{
+		Id:            g.id,
+		Words:         g.words.state(),
+		Phrases:       g.phrases.state(),
+		Phrase:        g.phrase,
+		Hands:         g.hands,
+		Trends:        g.trends,
+		SelectedCards: g.selectedCards,
+		PlayersReview: g.playersReview,
+		SubmittedAt:   g.submittedAt,
+		Shifts:        g.shifts,
+		ShiftsAt:      g.shiftsAt,
+		Handles:       g.handles,
+		Mulligans:     g.mulligans,
+		Swaps:         g.swaps,
+		Penalties:     g.penalties,
+		Bets:          g.bets,
+		Forecasts:     g.forecasts,
+		VotesReceived: g.votesReceived,
+		BestTurn:      g.bestTurn,
+		Wins:          g.wins,
+		Rematch:       g.rematch,
+		Turn:          g.turn,
+		Leaderboard:   g.leaderboard,
+		TrendConfig:   g.trendEngine.config,
+		ShockConfig:   g.shocks,
+		TrendHistory:  g.trendHistory,
+		Seed:          g.seed,
+		Step:          g.step,
+	}
+}
+
+// restore replaces the game with state, keeping the random source and the
+// outbox of the room goroutine. Nil maps are left to their fresh defaults.
+func (g *Game) restore(state gameState) {
+	g.reset()
+	g.id = state.Id
+	g.words = restoreDeck(state.Words, g.random)
+	g.phrases = restoreDeck(state.Phrases, g.random)
+	g.phrase = state.Phrase
+	g.trends = state.Trends
+	g.turn = state.Turn
+	g.shifts = state.Shifts
+	g.seed = state.Seed
+	g.step = state.Step
+	g.trendEngine = NewTrendEngine(state.TrendConfig, g.random)
+	if state.ShockConfig != nil {
+		g.shocks = state.ShockConfig
+	}
+	restoreMap(&g.hands, state.Hands)
+	restoreMap(&g.selectedCards, state.SelectedCards)
+	restoreMap(&g.playersReview, state.PlayersReview)
+	restoreMap(&g.submittedAt, state.SubmittedAt)
+	restoreMap(&g.shiftsAt, state.ShiftsAt)
+	restoreMap(&g.handles, state.Handles)
+	restoreMap(&g.mulligans, state.Mulligans)
+	restoreMap(&g.swaps, state.Swaps)
+	restoreMap(&g.penalties, state.Penalties)
+	restoreMap(&g.bets, state.Bets)
+	restoreMap(&g.forecasts, state.Forecasts)
+	restoreMap(&g.votesReceived, state.VotesReceived)
+	restoreMap(&g.bestTurn, state.BestTurn)
+	restoreMap(&g.wins, state.Wins)
+	restoreMap(&g.rematch, state.Rematch)
+	restoreMap(&g.leaderboard, state.Leaderboard)
+	if state.TrendHistory != nil {
+		g.trendHistory = state.TrendHistory
+	}
+}
+
+func restoreMap[K comparable, V any](target *map[K]V, value map[K]V) {
+	if value != nil {
+		*target = value
+	}
+}
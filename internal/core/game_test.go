@@ -0,0 +1,98 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"maps"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+	"testing"
+)
+
+func TestShocksApplyOnTopOfTurnTrends(t *testing.T) {
+	early, late := uuid.New(), uuid.New()
+	game := NewGame()
+	game.trends = map[uint]uint{1: 2, 2: 4}
+	game.shifts = []TrendShift{{Kind: "boost", Category: 2, Delta: 1}, {Kind: "crash", Category: 1, Delta: -1}}
+	game.shiftsAt = map[uuid.UUID]int{early: 0, late: 2}
+
+	playerTrends := game.playerTrends()
+	if _, ok := playerTrends[early]; ok {
+		t.Fatalf("player who saw no shock scores on %v instead of the turn trends", playerTrends[early])
+	}
+	expected := map[uint]uint{1: 1, 2: 4}
+	if !maps.Equal(playerTrends[late], expected) {
+		t.Fatalf("late player trends %v, expected %v", playerTrends[late], expected)
+	}
+}
+
+func TestShockIsCountedOnceAndOnlyForPlayersWhoSawIt(t *testing.T) {
+	early, late := uuid.New(), uuid.New()
+	room := entities.Room{Settings: withDefaultSettings(entities.RoomSettings{ShockMagnitude: 1})}
+	game := NewGame()
+	game.trends = map[uint]uint{1: 2, 2: 2}
+	turnTrends := maps.Clone(game.trends)
+
+	game.shiftsAt[early] = len(game.shifts)
+	game.trendShock(&room)
+	game.shiftsAt[late] = len(game.shifts)
+
+	if !maps.Equal(game.trends, turnTrends) {
+		t.Fatalf("shock moved the turn trends the next ones roll from to %v", game.trends)
+	}
+	shift := game.shifts[0]
+	expected := maps.Clone(turnTrends)
+	expected[shift.Category] = uint(int(expected[shift.Category]) + shift.Delta)
+
+	playerTrends := game.playerTrends()
+	if _, ok := playerTrends[early]; ok {
+		t.Fatalf("player who submitted before the shock scores on %v", playerTrends[early])
+	}
+	if !maps.Equal(playerTrends[late], expected) {
+		t.Fatalf("late player trends %v, expected the shock %+v counted once on %v", playerTrends[late], shift, turnTrends)
+	}
+}
+
+func TestSwapPenaltyIsChargedWithoutScore(t *testing.T) {
+	player := uuid.New()
+	game := NewGame()
+	game.penalties[player] = 3
+
+	breakdown := map[uuid.UUID]PlayerScore{}
+	game.applyPenalties(breakdown)
+	if score := breakdown[player]; score.Total != 0 || score.Balance != -3 {
+		t.Fatalf("total %d and balance %d, expected 0 and -3", score.Total, score.Balance)
+	}
+}
+
+func TestSwappedPointsCannotBeStaked(t *testing.T) {
+	player := uuid.New()
+	settings := withDefaultSettings(entities.RoomSettings{Betting: true, MaxStake: 5, SwapCost: 3})
+	game := NewGame()
+	game.trends = map[uint]uint{1: 2}
+	game.leaderboard[player] = 5
+	game.penalties[player] = 3
+
+	err := game.validateBet(RoomCmd{PlayerId: player, Category: 1, Stake: 3}, settings)
+	if apperr.From(err).Code != apperr.InsufficientScore {
+		t.Fatalf("staked points already spent on a swap, got %v", err)
+	}
+
+	err = game.validateHandAction(RoomCmd{Type: PlayerSwap, PlayerId: player, Cards: []uint{1}}, withDefaultSettings(entities.RoomSettings{Swaps: 2, SwapCost: 3}))
+	if apperr.From(err).Code != apperr.InsufficientScore {
+		t.Fatalf("swapped without the points for it, got %v", err)
+	}
+}
+
+func TestDisabledHandActionsAreReportedAsDisabled(t *testing.T) {
+	player := uuid.New()
+	game := NewGame()
+	settings := withDefaultSettings(entities.RoomSettings{})
+	settings.MulliganCards, settings.Swaps = 0, 0
+
+	for _, cmdType := range []uint{PlayerMulligan, PlayerSwap} {
+		err := game.validateHandAction(RoomCmd{Type: cmdType, PlayerId: player, Cards: []uint{1}}, settings)
+		if apperr.From(err).Code != apperr.FeatureDisabled {
+			t.Fatalf("disabled hand action %d answered %v", cmdType, err)
+		}
+	}
+}
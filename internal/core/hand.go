@@ -0,0 +1,117 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+)
+
+const RuleSwapPenalty = "swap_penalty"
+
+func (g *Game) checkCardsInHand(playerId uuid.UUID, cards []uint) error {
+	hand := make(map[uint]bool)
+	for _, word := range g.hands[playerId] {
+		hand[word.ID] = true
+	}
+
+	seen := make(map[uint]bool)
+	for _, card := range cards {
+		if seen[card] {
+			return apperr.New(apperr.DuplicateCard, apperr.Params{"card": card}, "card %d selected more than once", card)
+		}
+		seen[card] = true
+
+		if !hand[card] {
+			return apperr.New(apperr.CardNotInHand, apperr.Params{"card": card}, "card %d is not in hand", card)
+		}
+	}
+
+	return nil
+}
+
+func (g *Game) validateHandAction(cmd RoomCmd, settings entities.RoomSettings) error {
+	if _, ok := g.selectedCards[cmd.PlayerId]; ok {
+		return apperr.New(apperr.AlreadySubmitted, nil, "cards already selected for this turn")
+	}
+
+	switch cmd.Type {
+	case PlayerMulligan:
+		if settings.MulliganCards == 0 {
+			return featureDisabled("mulligan")
+		}
+		if g.mulligans[cmd.PlayerId] {
+			return apperr.New(apperr.ActionLimitReached, apperr.Params{"action": "mulligan", "limit": 1}, "mulligan already used in this game")
+		}
+		if len(cmd.Cards) == 0 || uint(len(cmd.Cards)) > settings.MulliganCards {
+			params := apperr.Params{"min": 1, "max": settings.MulliganCards, "received": len(cmd.Cards)}
+			return apperr.New(apperr.InvalidCardCount, params, "expected between 1 and %d cards, received %d", settings.MulliganCards, len(cmd.Cards))
+		}
+		break
+	case PlayerSwap:
+		if settings.Swaps == 0 {
+			return featureDisabled("swaps")
+		}
+		if g.swaps[cmd.PlayerId] >= settings.Swaps {
+			return apperr.New(apperr.ActionLimitReached, apperr.Params{"action": "swap", "limit": settings.Swaps}, "no swaps left for this turn")
+		}
+		if len(cmd.Cards) != 1 {
+			params := apperr.Params{"expected": 1, "received": len(cmd.Cards)}
+			return apperr.New(apperr.InvalidCardCount, params, "expected 1 card, received %d", len(cmd.Cards))
+		}
+		if err := g.checkFunds(cmd.PlayerId, settings.SwapCost, g.spent(cmd.PlayerId, settings)); err != nil {
+			return err
+		}
+		break
+	}
+
+	return g.checkCardsInHand(cmd.PlayerId, cmd.Cards)
+}
+
+// replaceCards draws the replacements before discarding, so a card is never
+// dealt back to the player who just got rid of it.
+func (g *Game) replaceCards(playerId uuid.UUID, cards []uint) {
+	hand := g.hands[playerId]
+	for i, word := range hand {
+		if !slices.Contains(cards, word.ID) {
+			continue
+		}
+		replacement, ok := g.words.Draw()
+		if !ok {
+			continue
+		}
+		g.words.Discard(word)
+		hand[i] = replacement
+	}
+}
+
+func (g *Game) mulligan(cmd RoomCmd) {
+	g.replaceCards(cmd.PlayerId, cmd.Cards)
+	g.mulligans[cmd.PlayerId] = true
+	g.sendHand(cmd.PlayerId)
+}
+
+func (g *Game) swap(cmd RoomCmd, settings entities.RoomSettings) {
+	g.replaceCards(cmd.PlayerId, cmd.Cards)
+	g.swaps[cmd.PlayerId] += 1
+	g.penalties[cmd.PlayerId] += settings.SwapCost
+	g.sendHand(cmd.PlayerId)
+}
+
+func (g *Game) sendHand(playerId uuid.UUID) {
+	g.out.toPlayer(playerId, PlayerEvent{Type: HandUpdated, Cards: slices.Clone(g.hands[playerId])})
+}
+
+// applyPenalties charges the swaps as wagers, so the cost comes out of the
+// leaderboard even when the turn scored nothing.
+func (g *Game) applyPenalties(breakdown map[uuid.UUID]PlayerScore) {
+	for player, penalty := range g.penalties {
+		if penalty == 0 {
+			continue
+		}
+		score := breakdown[player]
+		score.Wagers = append(score.Wagers, RuleScore{Rule: RuleSwapPenalty, Points: -int(penalty)})
+		score.total()
+		breakdown[player] = score
+	}
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"maps"
+	"pitch-perfect-server/internal/apperr"
+	database "pitch-perfect-server/internal/db"
+	"pitch-perfect-server/internal/entities"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// recordGameStart records the game along with the log entry of the command
+// starting it, which is logged right after the current one.
+func (g *Game) recordGameStart(room *entities.Room) {
+	if g.out.replaying {
+		return
+	}
+	game := entities.Game{ID: g.id, RoomId: room.ID, Settings: room.Settings, Version: ConfigVersion, Seed: g.seed, LogSeq: g.out.seq + 1}
+	// The configuration may be reloaded while the game is played or after,
+	// the replay has to show the one the game was started with.
+	trends, err := json.Marshal(g.trendEngine.config)
+	if err == nil {
+		game.Trends = string(trends)
+	}
+	shocks, err := json.Marshal(g.shocks)
+	if err == nil {
+		game.Shocks = string(shocks)
+	}
+	if tx := database.Db.Create(&game); tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("Impossible to record the game")
+	}
+}
+
+// recordedGame returns the id and the seed of the game started by the command
+// logged at logSeq.
+func recordedGame(roomId uuid.UUID, logSeq uint64) (uuid.UUID, int64) {
+	var game entities.Game
+	tx := database.Db.Select("id", "seed").Where("room_id = ? AND log_seq = ?", roomId, logSeq).First(&game)
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Str("room", roomId.String()).Uint64("seq", logSeq).Msg("Impossible to find the replayed game")
+	}
+	return game.ID, game.Seed
+}
+
+func (g *Game) recordTurn(votes map[uuid.UUID]uint, points map[uuid.UUID]uint) {
+	if g.out.replaying {
+		return
+	}
+	turn := entities.GameTurn{
+		GameId:   g.id,
+		Number:   g.turn + 1,
+		PhraseId: g.phrase.ID,
+		Cards:    maps.Clone(g.selectedCards),
+		Votes:    maps.Clone(votes),
+		Trends:   maps.Clone(g.trends),
+		Points:   maps.Clone(points),
+	}
+	if tx := database.Db.Create(&turn); tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("Impossible to record the turn")
+	}
+}
+
+func (g *Game) recordGameEnd(standings []Standing, endedAt time.Time) {
+	if g.out.replaying {
+		return
+	}
+	results := make([]entities.GamePlayerResult, 0, len(standings))
+	for _, standing := range standings {
+		results = append(results, entities.GamePlayerResult{GameId: g.id, PlayerId: standing.PlayerId, Score: standing.Score, Rank: standing.Rank})
+	}
+
+	err := database.Db.Transaction(func(tx *gorm.DB) error {
+		if len(results) > 0 {
+			if err := tx.Create(&results).Error; err != nil {
+				return err
+			}
+		}
+		// The GameEnded event is the next one recorded, and the last of the
+		// game in the room log.
+		updates := map[string]interface{}{"ended_at": endedAt, "end_seq": g.out.nextSeq()}
+		return tx.Model(&entities.Game{}).Where("id = ?", g.id).Updates(updates).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Impossible to record the game results")
+	}
+}
+
+func pagination(page int, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	return page, min(pageSize, MaxPageSize)
+}
+
+// GetPlayerGames lists the finished games of a player, most recent first.
+func GetPlayerGames(playerId uuid.UUID, page int, pageSize int) ([]entities.Game, int64, error) {
+	page, pageSize = pagination(page, pageSize)
+	query := database.Db.Model(&entities.Game{}).
+		Joins("JOIN game_player_results ON game_player_results.game_id = games.id").
+		Where("game_player_results.player_id = ? AND games.ended_at IS NOT NULL", playerId).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	games := make([]entities.Game, 0)
+	err := query.Preload("Results").
+		Order("games.created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&games).Error
+	return games, total, err
+}
+
+// GetGame returns a finished game. Running games are not found, their seed
+// would give their next draws away.
+func GetGame(gameId uuid.UUID) (entities.Game, error) {
+	var game entities.Game
+	err := database.Db.Preload("Turns", func(db *gorm.DB) *gorm.DB {
+		return db.Order("number")
+	}).Preload("Results", func(db *gorm.DB) *gorm.DB {
+		return db.Order("rank")
+	}).First(&game, "id = ? AND ended_at IS NOT NULL", gameId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return game, apperr.New(apperr.GameNotFound, apperr.Params{"gameId": gameId}, "game %s not found", gameId.String())
+	}
+	return game, err
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	database "pitch-perfect-server/internal/db"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "pitch-perfect-core")
+	if err != nil {
+		panic(err)
+	}
+
+	database.Path = filepath.Join(dir, "test.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+	database.Init()
+	ConfigPath = "../../config/1/game_configuration.json"
+	if err := InitConfig(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
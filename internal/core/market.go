@@ -0,0 +1,127 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+)
+
+const (
+	RuleBet      = "bet"
+	RuleForecast = "forecast"
+)
+
+type Bet struct {
+	Category uint
+	Stake    uint
+}
+
+type TrendForecast struct {
+	Category      uint
+	Level         uint
+	Probabilities []float64
+}
+
+func featureDisabled(feature string) error {
+	return apperr.New(apperr.FeatureDisabled, apperr.Params{"feature": feature}, "%s is disabled in this room", feature)
+}
+
+func (g *Game) checkFunds(playerId uuid.UUID, amount uint, spent uint) error {
+	available := g.leaderboard[playerId]
+	if amount+spent > available {
+		params := apperr.Params{"available": available - min(spent, available), "required": amount}
+		return apperr.New(apperr.InsufficientScore, params, "not enough points, %d required", amount)
+	}
+	return nil
+}
+
+func (g *Game) checkCategory(category uint) error {
+	if _, ok := g.trends[category]; !ok {
+		return apperr.New(apperr.InvalidCategory, apperr.Params{"category": category}, "unknown category %d", category)
+	}
+	return nil
+}
+
+func (g *Game) validateBet(cmd RoomCmd, settings entities.RoomSettings) error {
+	if !settings.Betting {
+		return featureDisabled("betting")
+	}
+	if _, ok := g.bets[cmd.PlayerId]; ok {
+		return apperr.New(apperr.ActionLimitReached, apperr.Params{"action": "bet", "limit": 1}, "bet already placed for this turn")
+	}
+	if cmd.Stake == 0 || cmd.Stake > settings.MaxStake {
+		return apperr.New(apperr.InvalidStake, apperr.Params{"min": 1, "max": settings.MaxStake}, "stake must be between 1 and %d", settings.MaxStake)
+	}
+	if err := g.checkCategory(cmd.Category); err != nil {
+		return err
+	}
+	return g.checkFunds(cmd.PlayerId, cmd.Stake, g.spent(cmd.PlayerId, settings))
+}
+
+func (g *Game) validateForecast(cmd RoomCmd, settings entities.RoomSettings) error {
+	if !settings.Forecasts {
+		return featureDisabled("forecasts")
+	}
+	if g.forecasts[cmd.PlayerId] {
+		return apperr.New(apperr.ActionLimitReached, apperr.Params{"action": "forecast", "limit": 1}, "forecast already bought for this turn")
+	}
+	if err := g.checkCategory(cmd.Category); err != nil {
+		return err
+	}
+	return g.checkFunds(cmd.PlayerId, settings.ForecastCost, g.spent(cmd.PlayerId, settings))
+}
+
+// spent is what a player already engaged this turn, swaps included, it cannot
+// be staked again.
+func (g *Game) spent(playerId uuid.UUID, settings entities.RoomSettings) uint {
+	spent := g.bets[playerId].Stake + g.penalties[playerId]
+	if g.forecasts[playerId] {
+		spent += settings.ForecastCost
+	}
+	return spent
+}
+
+func (g *Game) placeBet(cmd RoomCmd) {
+	g.bets[cmd.PlayerId] = Bet{Category: cmd.Category, Stake: cmd.Stake}
+}
+
+func (g *Game) forecast(cmd RoomCmd) {
+	g.forecasts[cmd.PlayerId] = true
+
+	level := g.trends[cmd.Category]
+	config := g.trendEngine.config
+	row := config.Matrix[min(level, config.Levels-1)]
+	var total uint
+	for _, weight := range row {
+		total += weight
+	}
+	probabilities := make([]float64, 0, len(row))
+	for _, weight := range row {
+		probabilities = append(probabilities, float64(weight)/float64(total))
+	}
+
+	forecast := TrendForecast{Category: cmd.Category, Level: level, Probabilities: probabilities}
+	g.out.toPlayer(cmd.PlayerId, PlayerEvent{Type: ForecastRevealed, Forecast: forecast})
+}
+
+// settleMarket wins the stake of every bet on a category that rose between
+// previous and the new trends, loses it otherwise, and charges the forecasts.
+func (g *Game) settleMarket(previous map[uint]uint, settings entities.RoomSettings, breakdown map[uuid.UUID]PlayerScore) {
+	for player, bet := range g.bets {
+		score := breakdown[player]
+		if g.trends[bet.Category] > previous[bet.Category] {
+			score.Wagers = append(score.Wagers, RuleScore{Rule: RuleBet, Points: int(bet.Stake)})
+		} else {
+			score.Wagers = append(score.Wagers, RuleScore{Rule: RuleBet, Points: -int(bet.Stake)})
+		}
+		score.total()
+		breakdown[player] = score
+	}
+
+	for player := range g.forecasts {
+		score := breakdown[player]
+		score.Wagers = append(score.Wagers, RuleScore{Rule: RuleForecast, Points: -int(settings.ForecastCost)})
+		score.total()
+		breakdown[player] = score
+	}
+}
@@ -1,15 +1,15 @@
 package core
 
 import (
+	"errors"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pitch-perfect-server/internal/apperr"
 	"pitch-perfect-server/internal/db"
 	"pitch-perfect-server/internal/entities"
-	"sync"
+	"time"
 )
 
-var playersIndex map[uuid.UUID]chan PlayerEvent
-var playersMutex sync.Mutex
-
 const (
 	ConnectionDown uint = iota
 	RoomJoined
@@ -19,6 +19,17 @@ const (
 	AllPlayerSelectedCards
 	TurnEnded
 	RoomCreated
+	RoomUpdated
+	PlayerDisconnected
+	PlayerReconnected
+	RoomSnapshot
+	HandUpdated
+	TrendShifted
+	ForecastRevealed
+	GameEnded
+	RematchVoted
+	ReplayFrame
+	ReplayEnded
 )
 
 type PlayerEvent struct {
@@ -35,6 +46,20 @@ type PlayerEvent struct {
 	LastTurn     bool
 	Leaderboards map[uuid.UUID]uint
 	Result       map[uuid.UUID]uint
+	Breakdown    map[uuid.UUID]PlayerScore
+	Votes        map[uuid.UUID]uint
+	Outcome      ReviewOutcome
+	Handles      map[uuid.UUID]uuid.UUID
+	TrendHistory []map[uint]uint
+	Shift        TrendShift
+	Forecast     TrendForecast
+	GameId       uuid.UUID
+	Standings    []Standing
+	Awards       []Award
+	Replayed     *PlayerEvent
+	Turn         uint
+	Deadline     time.Time
+	ServerTime   time.Time
 }
 
 func AddPlayer(name string) (entities.Player, error) {
@@ -47,28 +72,8 @@ func AddPlayer(name string) (entities.Player, error) {
 func GetPlayer(id uuid.UUID) (entities.Player, error) {
 	var player entities.Player
 	tx := database.Db.First(&player, id)
-	return player, tx.Error
-}
-
-func AddPlayerConnection(id uuid.UUID) (*chan PlayerEvent, error) {
-	playersMutex.Lock()
-	defer playersMutex.Unlock()
-	if playersIndex == nil {
-		playersIndex = make(map[uuid.UUID]chan PlayerEvent)
-	}
-	c, ok := playersIndex[id]
-	if ok {
-		return &c, nil
-	}
-	c = make(chan PlayerEvent)
-	playersIndex[id] = c
-	return &c, nil
-}
-
-func SendToAllConnectedPlayers(event PlayerEvent) {
-	playersMutex.Lock()
-	defer playersMutex.Unlock()
-	for _, c := range playersIndex {
-		c <- event
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return player, apperr.New(apperr.PlayerNotFound, apperr.Params{"playerId": id}, "player %s not found", id.String())
 	}
+	return player, tx.Error
 }
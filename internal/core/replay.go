@@ -0,0 +1,206 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReplaySpeed = 1.0
+	MaxReplaySpeed     = 16.0
+)
+
+// GameReplay is a self contained export of a finished game: what it was
+// played with and everything the room logged from its first command to the
+// one that ended it.
+type GameReplay struct {
+	Game          entities.Game
+	ConfigVersion string
+	Trends        TrendConfig
+	Shocks        []ShockConfig
+	Entries       []ReplayEntry
+}
+
+// ReplayEntry is a room log entry, events carry the time of the command that
+// produced them.
+type ReplayEntry struct {
+	Seq      uint64
+	Kind     string
+	Type     uint
+	PlayerId uuid.UUID
+	Time     time.Time
+	Seed     int64 `json:",omitempty"`
+	Payload  json.RawMessage
+}
+
+func replayUnavailable(gameId uuid.UUID, reason string) error {
+	return apperr.New(apperr.ReplayUnavailable, apperr.Params{"gameId": gameId, "reason": reason}, "no replay for game %s, %s", gameId.String(), reason)
+}
+
+func ExportReplay(gameId uuid.UUID) (GameReplay, error) {
+	game, err := GetGame(gameId)
+	if err != nil {
+		return GameReplay{}, err
+	}
+	if game.LogSeq == 0 || game.EndSeq < game.LogSeq {
+		return GameReplay{}, replayUnavailable(gameId, "game not logged")
+	}
+
+	entries, err := getRoomLogRange(game.RoomId, game.LogSeq, game.EndSeq)
+	if err != nil {
+		return GameReplay{}, err
+	}
+
+	replay := GameReplay{Game: game, ConfigVersion: game.Version, Entries: make([]ReplayEntry, 0)}
+	if game.Trends == "" || game.Shocks == "" {
+		return GameReplay{}, replayUnavailable(gameId, "configuration not recorded")
+	}
+	if err := json.Unmarshal([]byte(game.Trends), &replay.Trends); err != nil {
+		return GameReplay{}, err
+	}
+	if err := json.Unmarshal([]byte(game.Shocks), &replay.Shocks); err != nil {
+		return GameReplay{}, err
+	}
+	var last time.Time
+	for _, entry := range entries {
+		if entry.Kind == LogKindCommand {
+			var cmd RoomCmd
+			if err := json.Unmarshal([]byte(entry.Payload), &cmd); err != nil {
+				return GameReplay{}, err
+			}
+			last = cmd.Time
+			replay.Entries = append(replay.Entries, ReplayEntry{Seq: entry.Seq, Kind: entry.Kind, Type: entry.Type, PlayerId: entry.PlayerId, Time: cmd.Time, Seed: cmd.Seed, Payload: json.RawMessage(entry.Payload)})
+			continue
+		}
+
+		replay.Entries = append(replay.Entries, ReplayEntry{Seq: entry.Seq, Kind: entry.Kind, Type: entry.Type, PlayerId: entry.PlayerId, Time: last, Payload: json.RawMessage(entry.Payload)})
+	}
+	return replay, nil
+}
+
+// replayStream plays a replay to a single connection. The speed can be
+// changed while it plays and applies from the next event on.
+type replayStream struct {
+	gameId uuid.UUID
+	speed  float64
+	mutex  sync.Mutex
+	stop   chan struct{}
+}
+
+var replays = make(map[uuid.UUID]*replayStream)
+var replaysMutex sync.Mutex
+
+// ReplayGame streams the events of a finished game to connection, with the
+// timing they were sent with divided by speed. Asking again for the game
+// being replayed only changes its speed, asking for another one replaces it.
+func ReplayGame(connection *Connection, gameId uuid.UUID, speed float64) error {
+	if speed == 0 {
+		speed = DefaultReplaySpeed
+	}
+
+	replaysMutex.Lock()
+	stream, ok := replays[connection.Id]
+	replaysMutex.Unlock()
+	if ok && stream.gameId == gameId {
+		stream.setSpeed(speed)
+		return nil
+	}
+
+	replay, err := ExportReplay(gameId)
+	if err != nil {
+		return err
+	}
+
+	stream = &replayStream{gameId: gameId, speed: speed, stop: make(chan struct{})}
+	replaysMutex.Lock()
+	if previous, ok := replays[connection.Id]; ok {
+		close(previous.stop)
+	}
+	replays[connection.Id] = stream
+	replaysMutex.Unlock()
+
+	go stream.play(connection, replay)
+	return nil
+}
+
+func StopReplay(connectionId uuid.UUID) {
+	replaysMutex.Lock()
+	defer replaysMutex.Unlock()
+	if stream, ok := replays[connectionId]; ok {
+		close(stream.stop)
+		delete(replays, connectionId)
+	}
+}
+
+func (s *replayStream) setSpeed(speed float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.speed = speed
+}
+
+func (s *replayStream) getSpeed() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.speed
+}
+
+func (s *replayStream) play(connection *Connection, replay GameReplay) {
+	defer func() {
+		replaysMutex.Lock()
+		defer replaysMutex.Unlock()
+		if replays[connection.Id] == s {
+			delete(replays, connection.Id)
+		}
+	}()
+
+	var previous time.Time
+	for _, entry := range replay.Entries {
+		if entry.Kind != LogKindEvent {
+			continue
+		}
+
+		speed := s.getSpeed()
+		if !previous.IsZero() && !s.wait(connection, time.Duration(float64(entry.Time.Sub(previous))/speed)) {
+			return
+		}
+		previous = entry.Time
+
+		var event PlayerEvent
+		if err := json.Unmarshal(entry.Payload, &event); err != nil {
+			log.Error().Err(err).Uint64("seq", entry.Seq).Msg("Impossible to decode the replayed event")
+			continue
+		}
+		// Deadlines are moved to the replay timeline, so clients count down
+		// as they would have live.
+		now := RoomClock.Now()
+		if !event.Deadline.IsZero() {
+			event.Deadline = now.Add(time.Duration(float64(event.Deadline.Sub(entry.Time)) / speed))
+		}
+		event.ServerTime = now
+		connection.send(PlayerEvent{Type: ReplayFrame, GameId: s.gameId, PlayerId: entry.PlayerId, Replayed: &event})
+	}
+
+	connection.send(PlayerEvent{Type: ReplayEnded, GameId: s.gameId})
+}
+
+func (s *replayStream) wait(connection *Connection, duration time.Duration) bool {
+	fired := make(chan struct{})
+	timer := RoomClock.AfterFunc(duration, func() {
+		close(fired)
+	})
+	defer timer.Stop()
+
+	select {
+	case <-fired:
+		return true
+	case <-s.stop:
+		return false
+	case <-connection.Done:
+		return false
+	}
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"encoding/json"
+	"pitch-perfect-server/internal/entities"
+	"sync"
+	"testing"
+)
+
+func TestReplayStopsAtItsGameEnd(t *testing.T) {
+	host := newTestPlayer(t, "host")
+	guest := newTestPlayer(t, "guest")
+	settings := entities.RoomSettings{MinPlayers: 2, Turns: 1, HandSize: 4}
+	roomId := newTestRoom(t, settings, host, guest)
+
+	var dealt sync.WaitGroup
+	dealt.Add(1)
+	if err := playTurn(roomId, host, guest, settings.HandSize, &dealt); err != nil {
+		t.Fatal(err)
+	}
+	ended, err := host.waitFor(GameEnded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rematch logs more of the room after the game.
+	for _, player := range []testPlayer{host, guest} {
+		if err := SendRoomCmd(roomId, RoomCmd{Type: Rematch, PlayerId: player.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := host.waitFor(GameStarted); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := ExportReplay(ended.GameId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := replay.Entries[0], replay.Entries[len(replay.Entries)-1]
+	if first.Kind != LogKindCommand || first.Seq != replay.Game.LogSeq {
+		t.Fatalf("replay starts with %s %d at seq %d, expected the command at seq %d", first.Kind, first.Type, first.Seq, replay.Game.LogSeq)
+	}
+	var event PlayerEvent
+	if err := json.Unmarshal(last.Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if last.Type != GameEnded || event.GameId != ended.GameId {
+		t.Fatalf("replay ends with event %d of game %s, expected the end of %s", last.Type, event.GameId, ended.GameId)
+	}
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"pitch-perfect-server/internal/apperr"
+	"slices"
+	"time"
+)
+
+const (
+	TiePolicyShare    = "share"
+	TiePolicyNone     = "none"
+	TiePolicyEarliest = "earliest"
+	TiePolicyTrend    = "trend"
+)
+
+var tiePolicies = []string{TiePolicyShare, TiePolicyNone, TiePolicyEarliest, TiePolicyTrend}
+
+type ReviewWinner struct {
+	PlayerId   uuid.UUID
+	Place      uint
+	Votes      uint
+	Multiplier uint
+}
+
+type ReviewOutcome struct {
+	TiePolicy string
+	Tied      bool
+	Winners   []ReviewWinner
+}
+
+func validateTiePolicy(policy string) error {
+	if !slices.Contains(tiePolicies, policy) {
+		return apperr.New(apperr.InvalidSettings, apperr.Params{"field": "TiePolicy", "value": policy, "allowed": tiePolicies}, "unknown tie policy %s", policy)
+	}
+	return nil
+}
+
+// placeMultiplier grades the winner multiplier by one for every place after
+// the first, never going below one.
+func placeMultiplier(multiplier uint, place uint) uint {
+	if multiplier <= place {
+		return 1
+	}
+	return multiplier - place + 1
+}
+
+type reviewRanking struct {
+	votes       map[uuid.UUID]uint
+	submittedAt map[uuid.UUID]time.Time
+	trendScores map[uuid.UUID]uint
+}
+
+// groups returns the players that received at least one vote, grouped by
+// vote count from the most voted down.
+func (r reviewRanking) groups() [][]uuid.UUID {
+	byVotes := make(map[uint][]uuid.UUID)
+	for player, votes := range r.votes {
+		if votes > 0 {
+			byVotes[votes] = append(byVotes[votes], player)
+		}
+	}
+
+	counts := make([]uint, 0, len(byVotes))
+	for votes := range byVotes {
+		counts = append(counts, votes)
+	}
+	slices.Sort(counts)
+	slices.Reverse(counts)
+
+	groups := make([][]uuid.UUID, 0, len(counts))
+	for _, votes := range counts {
+		group := byVotes[votes]
+		slices.SortFunc(group, r.compareSubmission)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (r reviewRanking) compareSubmission(a uuid.UUID, b uuid.UUID) int {
+	if c := r.submittedAt[a].Compare(r.submittedAt[b]); c != 0 {
+		return c
+	}
+	return slices.Compare(a[:], b[:])
+}
+
+func (r reviewRanking) compareTrend(a uuid.UUID, b uuid.UUID) int {
+	if r.trendScores[a] != r.trendScores[b] {
+		if r.trendScores[a] > r.trendScores[b] {
+			return -1
+		}
+		return 1
+	}
+	return r.compareSubmission(a, b)
+}
+
+func (r reviewRanking) outcome(policy string, winners uint, multiplier uint) ReviewOutcome {
+	outcome := ReviewOutcome{TiePolicy: policy, Winners: make([]ReviewWinner, 0)}
+
+	var place uint = 1
+	for _, group := range r.groups() {
+		if place > winners {
+			break
+		}
+
+		tied := len(group) > 1
+		outcome.Tied = outcome.Tied || tied
+
+		switch {
+		case !tied || policy == TiePolicyShare:
+			for _, player := range group {
+				outcome.Winners = append(outcome.Winners, ReviewWinner{PlayerId: player, Place: place, Votes: r.votes[player], Multiplier: placeMultiplier(multiplier, place)})
+			}
+			place += uint(len(group))
+			break
+		case policy == TiePolicyNone:
+			place += uint(len(group))
+			break
+		default:
+			if policy == TiePolicyTrend {
+				slices.SortFunc(group, r.compareTrend)
+			}
+			for _, player := range group {
+				if place > winners {
+					break
+				}
+				outcome.Winners = append(outcome.Winners, ReviewWinner{PlayerId: player, Place: place, Votes: r.votes[player], Multiplier: placeMultiplier(multiplier, place)})
+				place += 1
+			}
+			break
+		}
+	}
+
+	return outcome
+}
+
+func (o ReviewOutcome) multipliers() map[uuid.UUID]uint {
+	multipliers := make(map[uuid.UUID]uint)
+	for _, winner := range o.Winners {
+		multipliers[winner.PlayerId] = winner.Multiplier
+	}
+	return multipliers
+}
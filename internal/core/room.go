@@ -1,12 +1,12 @@
 package core
 
 import (
-	"fmt"
+	"errors"
 	"github.com/google/uuid"
-	"github.com/jmcvetta/randutil"
 	"github.com/rs/zerolog/log"
 	"github.com/sourcegraph/conc/iter"
-	"math/rand"
+	"gorm.io/gorm"
+	"pitch-perfect-server/internal/apperr"
 	database "pitch-perfect-server/internal/db"
 	"pitch-perfect-server/internal/entities"
 	"sync"
@@ -15,15 +15,8 @@ import (
 
 var roomsIndex map[uuid.UUID]chan RoomCmd
 var roomsMutex sync.Mutex
-var deckWords []entities.Word
-var deckPhrases []entities.Phrase
-var phrase entities.Phrase
-var hands map[uuid.UUID][]entities.Word
-var trends map[uint]uint
-var selectedCards map[uuid.UUID][]uint
-var playersReview map[uuid.UUID]map[uuid.UUID]bool
-var turn uint
-var leaderboard map[uuid.UUID]uint
+
+const RoomCmdTimeout = 5 * time.Second
 
 const (
 	Joined uint = iota
@@ -34,22 +27,40 @@ const (
 	PlayerCardsSelectedTimeout
 	PlayerRatedOtherCards
 	PlayerRatedOtherCardsTimeout
+	UpdateSettings
+	Disconnected
+	Reconnected
+	GraceExpired
+	PlayerMulligan
+	PlayerSwap
+	TrendShock
+	PlayerBet
+	PlayerForecast
+	Rematch
+	RematchTimeout
 )
 
 const (
 	RoomStateWaiting uint = iota
 	RoomStateTurnStarted
 	RoomStateReview
+	RoomStateGameOver
 )
 
-const TurnMax = 4
-
 type RoomCmd struct {
-	Type     uint
-	PlayerId uuid.UUID
-	Player   entities.Player
-	Cards    []uint
-	Reviews  map[uuid.UUID]bool
+	Type         uint
+	PlayerId     uuid.UUID
+	ConnectionId uuid.UUID
+	Player       entities.Player
+	Cards        []uint
+	Ballot       Ballot
+	Category     uint
+	Stake        uint
+	Settings     entities.RoomSettings
+	Generation   uint
+	Seed         int64
+	Time         time.Time
+	Reply        chan error `json:"-"`
 }
 
 func InitRooms() error {
@@ -65,22 +76,28 @@ func InitRooms() error {
 			if roomsIndex == nil {
 				roomsIndex = make(map[uuid.UUID]chan RoomCmd)
 			}
+			room.Settings = withDefaultSettings(room.Settings)
 			c := make(chan RoomCmd)
 			roomsIndex[room.ID] = c
-			go roomCycle(*room, c)
+			go roomCycle(*room, c, RoomClock)
 			return
 		})
 
 	return nil
 }
 
-func CreateRoom(name string) (uuid.UUID, error) {
+func CreateRoom(hostId uuid.UUID, name string, settings entities.RoomSettings) (uuid.UUID, error) {
+	settings = withDefaultSettings(settings)
+	if err := ValidateRoomSettings(settings); err != nil {
+		return uuid.Nil, err
+	}
+
 	id, err := uuid.NewUUID()
 	if err != nil {
 		log.Error().Msg("Impossible to create UUID")
 	}
 
-	room := entities.Room{ID: id, Name: name}
+	room := entities.Room{ID: id, Name: name, HostId: hostId, Settings: settings}
 	database.Db.Create(&room)
 
 	c := make(chan RoomCmd)
@@ -91,7 +108,7 @@ func CreateRoom(name string) (uuid.UUID, error) {
 	}
 	roomsIndex[room.ID] = c
 
-	go roomCycle(room, c)
+	go roomCycle(room, c, RoomClock)
 
 	SendToAllConnectedPlayers(PlayerEvent{Type: RoomCreated, Room: room})
 
@@ -104,11 +121,19 @@ func GetAllRooms() ([]entities.Room, error) {
 	return rooms, tx.Error
 }
 
-func JoinRoom(joinerId uuid.UUID, roomId uuid.UUID) error {
+func getRoom(roomId uuid.UUID) (entities.Room, error) {
 	var room entities.Room
 	tx := database.Db.Preload("Players").First(&room, roomId)
-	if tx.Error != nil {
-		return tx.Error
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return room, roomNotFound(roomId)
+	}
+	return room, tx.Error
+}
+
+func JoinRoom(joinerId uuid.UUID, roomId uuid.UUID) error {
+	room, err := getRoom(roomId)
+	if err != nil {
+		return err
 	}
 
 	player, err := GetPlayer(joinerId)
@@ -116,18 +141,23 @@ func JoinRoom(joinerId uuid.UUID, roomId uuid.UUID) error {
 		return err
 	}
 
+	settings := withDefaultSettings(room.Settings)
+	if player.RoomId != room.ID && uint(len(room.Players)) >= settings.MaxPlayers {
+		return apperr.New(apperr.RoomFull, apperr.Params{"roomId": roomId, "maxPlayers": settings.MaxPlayers}, "room %s is full", roomId.String())
+	}
+
 	newPlayers := append(room.Players, player)
 	newPlayers, _ = uniqueSliceElements(newPlayers)
 	room.Players = newPlayers
-	tx = database.Db.Save(room)
-	if err != nil {
-		return err
+	tx := database.Db.Save(room)
+	if tx.Error != nil {
+		return tx.Error
 	}
 
 	player.RoomId = room.ID
 	tx = database.Db.Save(player)
-	if err != nil {
-		return err
+	if tx.Error != nil {
+		return tx.Error
 	}
 
 	c, err := GetChannelByRoom(roomId)
@@ -141,10 +171,9 @@ func JoinRoom(joinerId uuid.UUID, roomId uuid.UUID) error {
 }
 
 func LeaveRoom(leaverId uuid.UUID, roomId uuid.UUID) error {
-	var room entities.Room
-	tx := database.Db.Preload("Players").First(&room, roomId)
-	if tx.Error != nil {
-		return tx.Error
+	_, err := getRoom(roomId)
+	if err != nil {
+		return err
 	}
 
 	player, err := GetPlayer(leaverId)
@@ -152,14 +181,14 @@ func LeaveRoom(leaverId uuid.UUID, roomId uuid.UUID) error {
 		return err
 	}
 
-	player.RoomId = uuid.Nil
-	tx = database.Db.Save(player)
-	if tx.Error != nil {
-		return tx.Error
+	if player.RoomId != roomId {
+		return notInRoom(leaverId, roomId)
 	}
 
-	newPlayers := deleteElement(room.Players, leaverId)
-	room.Players = newPlayers
+	err = removePlayerFromRoom(leaverId)
+	if err != nil {
+		return err
+	}
 
 	c, err := GetChannelByRoom(roomId)
 	if err != nil {
@@ -171,6 +200,85 @@ func LeaveRoom(leaverId uuid.UUID, roomId uuid.UUID) error {
 	return nil
 }
 
+// DisconnectPlayer starts the reconnect grace of the player in the room they
+// are seated in, if any.
+func DisconnectPlayer(playerId uuid.UUID) error {
+	player, err := GetPlayer(playerId)
+	if err != nil {
+		return err
+	}
+
+	if player.RoomId == uuid.Nil {
+		return nil
+	}
+
+	c, err := GetChannelByRoom(player.RoomId)
+	if err != nil {
+		return err
+	}
+
+	*c <- RoomCmd{Type: Disconnected, PlayerId: playerId}
+
+	return nil
+}
+
+func ReconnectPlayer(playerId uuid.UUID, connectionId uuid.UUID) (uuid.UUID, error) {
+	player, err := GetPlayer(playerId)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if player.RoomId == uuid.Nil {
+		return uuid.Nil, nil
+	}
+
+	c, err := GetChannelByRoom(player.RoomId)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	*c <- RoomCmd{Type: Reconnected, PlayerId: playerId, ConnectionId: connectionId}
+
+	return player.RoomId, nil
+}
+
+func UpdateRoomSettings(playerId uuid.UUID, roomId uuid.UUID, settings entities.RoomSettings) error {
+	settings = withDefaultSettings(settings)
+	if err := ValidateRoomSettings(settings); err != nil {
+		return err
+	}
+
+	return SendRoomCmd(roomId, RoomCmd{Type: UpdateSettings, PlayerId: playerId, Settings: settings})
+}
+
+func SendRoomCmd(roomId uuid.UUID, cmd RoomCmd) error {
+	c, err := GetChannelByRoom(roomId)
+	if err != nil {
+		return err
+	}
+
+	cmd.Reply = make(chan error, 1)
+	*c <- cmd
+
+	select {
+	case err := <-cmd.Reply:
+		return err
+	case <-time.After(RoomCmdTimeout):
+		return apperr.New(apperr.RoomTimeout, apperr.Params{"roomId": roomId}, "room %s did not answer in time", roomId.String())
+	}
+}
+
+func removePlayerFromRoom(playerId uuid.UUID) error {
+	player, err := GetPlayer(playerId)
+	if err != nil {
+		return err
+	}
+
+	player.RoomId = uuid.Nil
+	tx := database.Db.Save(player)
+	return tx.Error
+}
+
 func GetChannelByRoom(roomId uuid.UUID) (*chan RoomCmd, error) {
 	roomsMutex.Lock()
 	defer roomsMutex.Unlock()
@@ -181,7 +289,34 @@ func GetChannelByRoom(roomId uuid.UUID) (*chan RoomCmd, error) {
 	if ok {
 		return &c, nil
 	}
-	return nil, fmt.Errorf("math: square root of negative number %s", roomId.String())
+	return nil, roomNotFound(roomId)
+}
+
+func roomNotFound(roomId uuid.UUID) error {
+	return apperr.New(apperr.RoomNotFound, apperr.Params{"roomId": roomId}, "room %s not found", roomId.String())
+}
+
+func notInRoom(playerId uuid.UUID, roomId uuid.UUID) error {
+	return apperr.New(apperr.NotInRoom, apperr.Params{"playerId": playerId, "roomId": roomId}, "player %s is not in room %s", playerId.String(), roomId.String())
+}
+
+func wrongPhase(cmd RoomCmd, room *entities.Room) error {
+	phase := phaseName(room.State)
+	return apperr.New(apperr.WrongPhase, apperr.Params{"command": cmd.Type, "phase": phase}, "cmd %d not valid during %s phase", cmd.Type, phase)
+}
+
+func phaseName(state uint) string {
+	switch state {
+	case RoomStateWaiting:
+		return "waiting"
+	case RoomStateTurnStarted:
+		return "turn_started"
+	case RoomStateReview:
+		return "review"
+	case RoomStateGameOver:
+		return "game_over"
+	}
+	return "unknown"
 }
 
 func uniqueSliceElements[T comparable](inputSlice []T) ([]T, bool) {
@@ -209,378 +344,284 @@ func deleteElement(players []entities.Player, elem uuid.UUID) []entities.Player
 	return result
 }
 
-func roomCycle(room entities.Room, c chan RoomCmd) {
+func roomCycle(room entities.Room, c chan RoomCmd, clock Clock) {
+	roomClock := &roomClock{clock: clock, now: clock.Now()}
+	game := NewGame()
+	game.out = newRoomOutbox(room.ID)
+	timer := newPhaseTimer(roomClock, c)
+	graces := newGraceTimers(roomClock, c)
+	recoverRoom(&room, game, timer, graces, roomClock)
 	for {
 		Cmd := <-c
 
-		switch Cmd.Type {
-		case Joined:
-			joiner := Cmd.Player
-			iter.ForEach(room.Players, func(player *entities.Player) {
-				playersMutex.Lock()
-				defer playersMutex.Unlock()
-
-				chl, ok := playersIndex[player.ID]
-				if ok {
-					chl <- PlayerEvent{Type: RoomJoined, Player: joiner}
-				}
-			})
-
-			newPlayers := append(room.Players, joiner)
-			newPlayers, _ = uniqueSliceElements(newPlayers)
-			room.Players = newPlayers
-			break
-		case Leave:
-			leaver := Cmd.PlayerId
-			newPlayers := deleteElement(room.Players, leaver)
-			room.Players = newPlayers
-
-			if len(room.Players) > 0 {
-				iter.ForEach(room.Players, func(player *entities.Player) {
-					playersMutex.Lock()
-					defer playersMutex.Unlock()
-
-					chl, ok := playersIndex[player.ID]
-					if ok {
-						chl <- PlayerEvent{Type: RoomLeaved, PlayerId: leaver}
-					}
-				})
-			} else {
-				room.State = RoomStateWaiting
+		if isTimeoutCmd(Cmd) && !timer.isCurrent(Cmd) {
+			log.Debug().Interface("cmd", Cmd).Msg("Dropped a stale timeout")
+			continue
+		}
+		if Cmd.Type == GraceExpired && !graces.isCurrent(Cmd) {
+			log.Debug().Interface("cmd", Cmd).Msg("Dropped a stale grace expiry")
+			continue
+		}
+
+		roomClock.now = clock.Now()
+		Cmd.Time = roomClock.now
+		Cmd.Seed = game.commandSeed(roomClock.now)
+		game.random.Seed(Cmd.Seed)
+
+		err := handleRoomCmd(Cmd, &room, game, timer, graces)
+		if err != nil {
+			log.Warn().Err(err).Interface("cmd", Cmd).Msg("Rejected room cmd")
+			if isViolation(err) {
+				recordViolation(Cmd.PlayerId, room.ID, err)
 			}
-			break
-		default:
-			handleCmdDuringRoomState(Cmd, &room, &c)
-			break
+		}
+
+		if game.out.flush(Cmd, err == nil) {
+			game.step += 1
+		}
+		if game.out.snapshotDue() {
+			game.out.checkpoint(&room, game, timer)
+		}
+		game.out.deliver()
+
+		if Cmd.Reply != nil {
+			Cmd.Reply <- err
 		}
 	}
 }
 
-func handleCmdDuringRoomState(cmd RoomCmd, room *entities.Room, c *chan RoomCmd) {
+func handleRoomCmd(cmd RoomCmd, room *entities.Room, game *Game, timer *phaseTimer, graces *graceTimers) error {
+	switch cmd.Type {
+	case Joined:
+		joiner := cmd.Player
+		graces.cancel(joiner.ID)
+		game.out.toRoom(room, PlayerEvent{Type: RoomJoined, Player: joiner})
+
+		newPlayers := append(room.Players, joiner)
+		newPlayers, _ = uniqueSliceElements(newPlayers)
+		room.Players = newPlayers
+
+		if room.HostId == uuid.Nil {
+			room.HostId = joiner.ID
+			database.Db.Save(room)
+		}
+		return nil
+	case Leave:
+		graces.cancel(cmd.PlayerId)
+		game.leaveRoomCycle(room, cmd.PlayerId, timer)
+		return nil
+	case Disconnected:
+		if !isInRoom(room, cmd.PlayerId) {
+			return nil
+		}
+		graces.start(cmd.PlayerId, seconds(room.Settings.ReconnectGrace))
+		game.out.toRoom(room, PlayerEvent{Type: PlayerDisconnected, PlayerId: cmd.PlayerId})
+		return nil
+	case Reconnected:
+		wasDisconnected := graces.cancel(cmd.PlayerId)
+		if !wasDisconnected && !isInRoom(room, cmd.PlayerId) {
+			return nil
+		}
+		game.out.toConnection(cmd.PlayerId, cmd.ConnectionId, game.snapshot(cmd.PlayerId, room, timer))
+		if wasDisconnected {
+			game.out.toRoom(room, PlayerEvent{Type: PlayerReconnected, PlayerId: cmd.PlayerId})
+		}
+		return nil
+	case GraceExpired:
+		if !graces.isCurrent(cmd) {
+			return nil
+		}
+		graces.cancel(cmd.PlayerId)
+		if err := removePlayerFromRoom(cmd.PlayerId); err != nil {
+			log.Error().Err(err).Msg("Impossible to remove the disconnected player")
+		}
+		game.leaveRoomCycle(room, cmd.PlayerId, timer)
+		return nil
+	default:
+		if err := validateMembership(cmd, room); err != nil {
+			return err
+		}
+		return game.handleCmdDuringRoomState(cmd, room, timer)
+	}
+}
+
+func (g *Game) leaveRoomCycle(room *entities.Room, leaver uuid.UUID, timer *phaseTimer) {
+	newPlayers := deleteElement(room.Players, leaver)
+	room.Players = newPlayers
+
+	if room.HostId == leaver && len(room.Players) > 0 {
+		room.HostId = room.Players[0].ID
+		database.Db.Save(room)
+	}
+
+	if len(room.Players) > 0 {
+		g.out.toRoom(room, PlayerEvent{Type: RoomLeaved, PlayerId: leaver})
+	} else {
+		timer.stop()
+		room.State = RoomStateWaiting
+	}
+
+	// The last holdout leaving makes the rematch unanimous.
+	if room.State == RoomStateGameOver {
+		delete(g.rematch, leaver)
+		if g.rematchAgreed(room) {
+			g.startRematch(room, timer)
+		}
+	}
+}
+
+func isInRoom(room *entities.Room, playerId uuid.UUID) bool {
+	for _, player := range room.Players {
+		if player.ID == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Game) handleCmdDuringRoomState(cmd RoomCmd, room *entities.Room, timer *phaseTimer) error {
 	switch room.State {
 	case RoomStateWaiting:
-		handleCmdDuringWaiting(cmd, room, c)
-		break
+		return g.handleCmdDuringWaiting(cmd, room, timer)
 	case RoomStateTurnStarted:
-		handleCmdDuringTurnStarted(cmd, room, c)
-		break
+		return g.handleCmdDuringTurnStarted(cmd, room, timer)
 	case RoomStateReview:
-		handleCmdDuringReview(cmd, room, c)
-		break
+		return g.handleCmdDuringReview(cmd, room, timer)
+	case RoomStateGameOver:
+		return g.handleCmdDuringGameOver(cmd, room, timer)
 	}
+	return wrongPhase(cmd, room)
 }
 
-func handleCmdDuringWaiting(cmd RoomCmd, room *entities.Room, c *chan RoomCmd) {
+func (g *Game) handleCmdDuringWaiting(cmd RoomCmd, room *entities.Room, timer *phaseTimer) error {
 	switch cmd.Type {
 	case PlayerReady:
 		if len(room.PlayersReady) == 0 && len(room.Players) > 1 {
-			timeout(RoomCmd{Type: PlayerReadyTimeout}, 15*time.Second, c)
+			timer.start(RoomCmd{Type: PlayerReadyTimeout}, seconds(room.Settings.ReadyTimeout))
 		}
 
 		newReadyPlayers := append(room.PlayersReady, cmd.PlayerId)
 		newReadyPlayers, _ = uniqueSliceElements(newReadyPlayers)
 		room.PlayersReady = newReadyPlayers
-		if len(room.PlayersReady) >= len(room.Players) && len(room.Players) >= 1 {
-			gameStart(room)
-			startTurn(room)
-			timeout(RoomCmd{Type: PlayerCardsSelectedTimeout}, time.Minute, c)
+		if len(room.PlayersReady) >= len(room.Players) && uint(len(room.Players)) >= room.Settings.MinPlayers {
+			g.startGame(room, timer)
 		}
 		break
 	case PlayerReadyTimeout:
-		gameStart(room)
-		startTurn(room)
-		timeout(RoomCmd{Type: PlayerCardsSelectedTimeout}, time.Minute, c)
+		if uint(len(room.Players)) < room.Settings.MinPlayers {
+			return apperr.New(apperr.NotEnoughPlayers, apperr.Params{"minPlayers": room.Settings.MinPlayers}, "not enough players to start the game")
+		}
+		g.startGame(room, timer)
 		break
-	default:
-		log.Error().Interface("cmd", cmd).Msg("Received a cmd not valid during waiting phase")
+	case UpdateSettings:
+		if cmd.PlayerId != room.HostId {
+			return apperr.New(apperr.NotHost, apperr.Params{"hostId": room.HostId}, "only the room host can update the settings")
+		}
+		if uint(len(room.Players)) > cmd.Settings.MaxPlayers {
+			return apperr.New(apperr.InvalidSettings, apperr.Params{"field": "MaxPlayers", "min": len(room.Players)}, "max players lower than the players in the room")
+		}
+		room.Settings = cmd.Settings
+		database.Db.Save(room)
+		g.out.toRoom(room, PlayerEvent{Type: RoomUpdated, Room: *room})
 		break
+	default:
+		return wrongPhase(cmd, room)
 	}
+	return nil
 }
 
-func handleCmdDuringTurnStarted(cmd RoomCmd, room *entities.Room, c *chan RoomCmd) {
+func (g *Game) handleCmdDuringTurnStarted(cmd RoomCmd, room *entities.Room, timer *phaseTimer) error {
 	switch cmd.Type {
 	case PlayerCardsSelected:
-		selectedCards[cmd.PlayerId] = cmd.Cards
-		removeUsedCards(cmd.PlayerId, cmd.Cards)
-		if len(selectedCards) >= len(room.Players) {
-			allPlayerSelectedCards(room)
-			timeout(RoomCmd{Type: PlayerRatedOtherCardsTimeout}, time.Minute, c)
+		if err := g.validateSelection(cmd); err != nil {
+			return err
+		}
+		g.selectedCards[cmd.PlayerId] = cmd.Cards
+		g.submittedAt[cmd.PlayerId] = timer.clock.Now()
+		g.shiftsAt[cmd.PlayerId] = len(g.shifts)
+		g.removeUsedCards(cmd.PlayerId, cmd.Cards)
+		if len(g.selectedCards) >= len(room.Players) {
+			g.startReview(room, timer)
 		}
 		break
 	case PlayerCardsSelectedTimeout:
-		allPlayerSelectedCards(room)
-		timeout(RoomCmd{Type: PlayerRatedOtherCardsTimeout}, time.Minute, c)
+		g.startReview(room, timer)
 		break
-	default:
-		log.Error().Interface("cmd", cmd).Msg("Received a cmd not valid during turn started phase")
+	case TrendShock:
+		g.trendShock(room)
 		break
-	}
-}
-
-func handleCmdDuringReview(cmd RoomCmd, room *entities.Room, c *chan RoomCmd) {
-	switch cmd.Type {
-	case PlayerRatedOtherCards:
-		playersReview[cmd.PlayerId] = cmd.Reviews
-		if len(playersReview) >= len(room.Players) {
-			endTurn(room)
+	case PlayerBet:
+		if err := g.validateBet(cmd, room.Settings); err != nil {
+			return err
 		}
+		g.placeBet(cmd)
 		break
-	case PlayerRatedOtherCardsTimeout:
-		endTurn(room)
-		break
-	default:
-		log.Error().Interface("cmd", cmd).Msg("Received a cmd not valid during review phase")
-		break
-	}
-}
-
-func shuffleDeck[T comparable](deck *[]T) {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	r.Shuffle(len(*deck), func(i, j int) { (*deck)[i], (*deck)[j] = (*deck)[j], (*deck)[i] })
-}
-
-func generateTrends() {
-	matrix := make([][]randutil.Choice, 5)
-	matrix[0] = make([]randutil.Choice, 5)
-	matrix[0][0] = randutil.Choice{Weight: 30, Item: 0}
-	matrix[0][1] = randutil.Choice{Weight: 25, Item: 1}
-	matrix[0][2] = randutil.Choice{Weight: 20, Item: 2}
-	matrix[0][3] = randutil.Choice{Weight: 15, Item: 3}
-	matrix[0][4] = randutil.Choice{Weight: 10, Item: 4}
-
-	matrix[1] = make([]randutil.Choice, 5)
-	matrix[1][0] = randutil.Choice{Weight: 10, Item: 0}
-	matrix[1][1] = randutil.Choice{Weight: 30, Item: 1}
-	matrix[1][2] = randutil.Choice{Weight: 25, Item: 2}
-	matrix[1][3] = randutil.Choice{Weight: 20, Item: 3}
-	matrix[1][4] = randutil.Choice{Weight: 15, Item: 4}
-
-	matrix[2] = make([]randutil.Choice, 5)
-	matrix[2][0] = randutil.Choice{Weight: 15, Item: 0}
-	matrix[2][1] = randutil.Choice{Weight: 10, Item: 1}
-	matrix[2][2] = randutil.Choice{Weight: 30, Item: 2}
-	matrix[2][3] = randutil.Choice{Weight: 25, Item: 3}
-	matrix[2][4] = randutil.Choice{Weight: 20, Item: 4}
-
-	matrix[3] = make([]randutil.Choice, 5)
-	matrix[3][0] = randutil.Choice{Weight: 20, Item: 0}
-	matrix[3][1] = randutil.Choice{Weight: 15, Item: 1}
-	matrix[3][2] = randutil.Choice{Weight: 10, Item: 2}
-	matrix[3][3] = randutil.Choice{Weight: 30, Item: 3}
-	matrix[3][4] = randutil.Choice{Weight: 25, Item: 4}
-
-	matrix[4] = make([]randutil.Choice, 5)
-	matrix[4][0] = randutil.Choice{Weight: 25, Item: 0}
-	matrix[4][1] = randutil.Choice{Weight: 20, Item: 1}
-	matrix[4][2] = randutil.Choice{Weight: 15, Item: 2}
-	matrix[4][3] = randutil.Choice{Weight: 10, Item: 3}
-	matrix[4][4] = randutil.Choice{Weight: 30, Item: 4}
-
-	if trends == nil {
-		oneRnd := rand.Intn(5)
-		twoRnd := rand.Intn(5)
-		threeRnd := rand.Intn(5)
-		fourRnd := rand.Intn(5)
-		fiveRnd := rand.Intn(5)
-		trends = make(map[uint]uint)
-		trends[1] = uint(oneRnd)
-		trends[2] = uint(twoRnd)
-		trends[3] = uint(threeRnd)
-		trends[4] = uint(fourRnd)
-		trends[5] = uint(fiveRnd)
-	} else {
-		for key, value := range trends {
-			choice, _ := randutil.WeightedChoice(matrix[value])
-			trends[key] = uint(choice.Item.(int))
+	case PlayerForecast:
+		if err := g.validateForecast(cmd, room.Settings); err != nil {
+			return err
 		}
-	}
-}
-
-func generateWordCategory() map[uint]uint {
-	words, _ := GetWords()
-	wordsCategory := make(map[uint]uint)
-	for _, v := range words {
-		wordsCategory[v.ID] = v.CategoryId
-	}
-	return wordsCategory
-}
-
-func generateHands(room *entities.Room) {
-	if hands == nil {
-		hands = make(map[uuid.UUID][]entities.Word)
-	}
-	for _, v := range room.Players {
-		hand, ok := hands[v.ID]
-		if !ok || hand == nil {
-			hand = make([]entities.Word, 0)
+		g.forecast(cmd)
+		break
+	case PlayerMulligan:
+		if err := g.validateHandAction(cmd, room.Settings); err != nil {
+			return err
 		}
-
-		missingCard := 4 - len(hand)
-		for i := 0; i < missingCard; i++ {
-			hand = append(hand, deckWords[0])
-			deckWords = deckWords[1 : len(deckWords)-1]
+		g.mulligan(cmd)
+		break
+	case PlayerSwap:
+		if err := g.validateHandAction(cmd, room.Settings); err != nil {
+			return err
 		}
-
-		hands[v.ID] = hand
+		g.swap(cmd, room.Settings)
+		break
+	default:
+		return wrongPhase(cmd, room)
 	}
+	return nil
 }
 
-func removeUsedCards(playerId uuid.UUID, cards []uint) {
-	playerHand := hands[playerId]
-	newPlayerHand := make([]entities.Word, 0)
-	for _, w := range playerHand {
-		found := false
-		for _, c := range cards {
-			found = found || c == w.ID
-		}
-
-		if !found {
-			newPlayerHand = append(newPlayerHand, w)
+func (g *Game) handleCmdDuringReview(cmd RoomCmd, room *entities.Room, timer *phaseTimer) error {
+	switch cmd.Type {
+	case PlayerRatedOtherCards:
+		ballot, err := cmd.Ballot.resolve(g.handles)
+		if err != nil {
+			return err
 		}
-	}
-	hands[playerId] = newPlayerHand
-}
-
-func generatePhrase() {
-	phrase = deckPhrases[0]
-	deckPhrases = deckPhrases[1 : len(deckPhrases)-1]
-}
-
-func resetInternal() {
-	selectedCards = make(map[uuid.UUID][]uint)
-	playersReview = make(map[uuid.UUID]map[uuid.UUID]bool)
-}
-
-func getReviewWinner(room *entities.Room) uuid.UUID {
-	reviewCount := make(map[uuid.UUID]uint)
-	for _, p := range room.Players {
-		reviewCount[p.ID] = 0
-	}
-
-	for p, reviews := range playersReview {
-		for id, liked := range reviews {
-			if liked && p != id {
-				reviewCount[id] += 1
-			}
+		cmd.Ballot = ballot
+		if err := g.validateReview(cmd, room.Settings); err != nil {
+			return err
 		}
-	}
-
-	var m uint
-	var winner uuid.UUID
-	for p, c := range reviewCount {
-		if c > m {
-			m = c
-			winner = p
+		g.playersReview[cmd.PlayerId] = cmd.Ballot
+		if len(g.playersReview) >= len(room.Players) {
+			g.nextTurn(room, timer)
 		}
+		break
+	case PlayerRatedOtherCardsTimeout:
+		g.nextTurn(room, timer)
+		break
+	default:
+		return wrongPhase(cmd, room)
 	}
-
-	return winner
-}
-
-func gameStart(room *entities.Room) {
-	room.State += 1
-	database.Db.Save(&room)
-	deckWords, _ = GetWords()
-	deckPhrases, _ = GetPhrases()
-	shuffleDeck(&deckWords)
-	shuffleDeck(&deckPhrases)
-	generateTrends()
-	generateWordCategory()
-	leaderboard = make(map[uuid.UUID]uint)
-	iter.ForEach(room.Players,
-		func(player *entities.Player) {
-			playersMutex.Lock()
-			defer playersMutex.Unlock()
-			c, ok := playersIndex[(*player).ID]
-			if ok {
-				c <- PlayerEvent{Type: GameStarted, Trends: trends}
-			}
-		})
+	return nil
 }
 
-func startTurn(room *entities.Room) {
-	generatePhrase()
-	generateHands(room)
-	resetInternal()
-	room.State = RoomStateTurnStarted
-	database.Db.Save(&room)
-	iter.ForEach(room.Players,
-		func(player *entities.Player) {
-			hand, ok := hands[player.ID]
-			if !ok {
-				log.Error().Msg("Impossible to get player hand in state")
-				return
-			}
-
-			playersMutex.Lock()
-			defer playersMutex.Unlock()
-			c, ok := playersIndex[(*player).ID]
-			if ok {
-				c <- PlayerEvent{Type: TurnStarted, Cards: hand, Phrase: phrase}
-			}
-		})
+func (g *Game) startGame(room *entities.Room, timer *phaseTimer) {
+	room.PlayersReady = nil
+	timer.start(RoomCmd{Type: PlayerCardsSelectedTimeout}, seconds(room.Settings.SelectionTimeout))
+	g.gameStart(room, timer)
+	g.startTurn(room, timer)
 }
 
-func allPlayerSelectedCards(room *entities.Room) {
-	room.State += 1
-	database.Db.Save(&room)
-	iter.ForEach(room.Players,
-		func(player *entities.Player) {
-			playersMutex.Lock()
-			defer playersMutex.Unlock()
-			c, ok := playersIndex[(*player).ID]
-			if ok {
-				c <- PlayerEvent{Type: AllPlayerSelectedCards, PlayersCards: selectedCards}
-			}
-		})
+func (g *Game) startReview(room *entities.Room, timer *phaseTimer) {
+	timer.start(RoomCmd{Type: PlayerRatedOtherCardsTimeout}, seconds(room.Settings.ReviewTimeout))
+	g.allPlayerSelectedCards(room, timer)
 }
 
-func endTurn(room *entities.Room) {
-	room.State = 0
-	database.Db.Save(&room)
-
-	generateTrends()
-	winner := getReviewWinner(room)
-	wordCategory := generateWordCategory()
-
-	turnLeaderboard := make(map[uuid.UUID]uint)
-	for player, cards := range selectedCards {
-		for _, card := range cards {
-			points := trends[wordCategory[card]] + 1
-			if player == winner {
-				points *= 2
-			}
-			turnLeaderboard[player] = points
-		}
-	}
-
-	for _, player := range room.Players {
-		leaderboard[player.ID] += turnLeaderboard[player.ID]
+func (g *Game) nextTurn(room *entities.Room, timer *phaseTimer) {
+	if g.endTurn(room) {
+		g.gameOver(room, timer)
+		return
 	}
-
-	turn += 1
-	GameEnded := turn >= TurnMax
-
-	iter.ForEach(room.Players,
-		func(player *entities.Player) {
-			playersMutex.Lock()
-			defer playersMutex.Unlock()
-			c, ok := playersIndex[(*player).ID]
-			if ok {
-				c <- PlayerEvent{Type: TurnEnded, Trends: trends, Leaderboards: leaderboard, Result: turnLeaderboard, LastTurn: GameEnded}
-			}
-		})
-
-	if !GameEnded {
-		startTurn(room)
-	}
-}
-
-func timeout(cmd RoomCmd, duration time.Duration, c *chan RoomCmd) {
-	go func() {
-		time.Sleep(duration)
-		if c != nil {
-			*c <- cmd
-		}
-	}()
+	timer.start(RoomCmd{Type: PlayerCardsSelectedTimeout}, seconds(room.Settings.SelectionTimeout))
+	g.startTurn(room, timer)
 }
@@ -0,0 +1,329 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	database "pitch-perfect-server/internal/db"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	LogKindCommand = "command"
+	LogKindEvent   = "event"
+)
+
+// SnapshotInterval is the number of logged commands between two checkpoints
+// of a room.
+var SnapshotInterval uint = 50
+
+// roomState is what a checkpoint stores, the room goroutine is rebuilt from
+// it before replaying the commands logged after it.
+type roomState struct {
+	Room  entities.Room
+	Game  gameState
+	Timer timerState
+	Time  time.Time
+}
+
+// roomOutbox is the only way the room goroutine talks to the players. Events
+// are held back until the command that produced them is logged, so players
+// never see what a restart could not rebuild, and are not sent at all while
+// the room replays its log.
+type roomOutbox struct {
+	roomId        uuid.UUID
+	logging       bool
+	replaying     bool
+	seq           uint64
+	sinceSnapshot uint
+	mutex         sync.Mutex
+	entries       []entities.RoomLogEntry
+	sends         []func()
+}
+
+func newRoomOutbox(roomId uuid.UUID) *roomOutbox {
+	return &roomOutbox{roomId: roomId, logging: true}
+}
+
+func (o *roomOutbox) toRoom(room *entities.Room, event PlayerEvent) {
+	o.record(uuid.Nil, event)
+	// The players are the ones in the room now, not when the event goes out.
+	players := slices.Clone(room.Players)
+	o.send(func() {
+		sendToRoom(&entities.Room{Players: players}, event)
+	})
+}
+
+func (o *roomOutbox) toPlayer(playerId uuid.UUID, event PlayerEvent) {
+	o.record(playerId, event)
+	o.send(func() {
+		sendToPlayer(playerId, event)
+	})
+}
+
+func (o *roomOutbox) send(f func()) {
+	if o.replaying {
+		return
+	}
+	if !o.logging {
+		f()
+		return
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.sends = append(o.sends, f)
+}
+
+// toConnection answers a single connection, such answers are not part of the
+// game and are never logged.
+func (o *roomOutbox) toConnection(playerId uuid.UUID, connectionId uuid.UUID, event PlayerEvent) {
+	if !o.replaying {
+		sendToConnection(playerId, connectionId, event)
+	}
+}
+
+func (o *roomOutbox) record(playerId uuid.UUID, event PlayerEvent) {
+	if !o.logging || o.replaying {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Msg("Impossible to encode the room event")
+		return
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.entries = append(o.entries, entities.RoomLogEntry{Kind: LogKindEvent, Type: event.Type, PlayerId: playerId, Payload: string(payload)})
+}
+
+// nextSeq is the seq the next recorded event is logged at, the command being
+// handled is logged before its events.
+func (o *roomOutbox) nextSeq() uint64 {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.seq + 2 + uint64(len(o.entries))
+}
+
+// flush writes cmd and the events it produced to the room log, or drops the
+// events when cmd is not worth logging. It reports whether cmd moved the game
+// on. The events are sent by deliver once flush and the checkpoint that may
+// follow it are done.
+//
+// When the log cannot be written the game already moved on anyway, so a
+// checkpoint is asked for to let a restart rebuild what the players will see.
+func (o *roomOutbox) flush(cmd RoomCmd, accepted bool) bool {
+	o.mutex.Lock()
+	entries := o.entries
+	o.entries = nil
+	o.mutex.Unlock()
+
+	cmd, ok := loggedCmd(cmd)
+	if !o.logging || o.replaying || !accepted || !ok {
+		return false
+	}
+
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		log.Error().Err(err).Msg("Impossible to encode the room cmd")
+		o.sinceSnapshot = SnapshotInterval
+		return true
+	}
+	entries = append([]entities.RoomLogEntry{{Kind: LogKindCommand, Type: cmd.Type, PlayerId: cmd.PlayerId, Payload: string(payload)}}, entries...)
+
+	seq := o.seq
+	for i := range entries {
+		seq += 1
+		entries[i].RoomId = o.roomId
+		entries[i].Seq = seq
+	}
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&entries).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Str("room", o.roomId.String()).Msg("Impossible to append to the room log")
+		o.sinceSnapshot = SnapshotInterval
+		return true
+	}
+	o.seq = seq
+	o.sinceSnapshot += 1
+	return true
+}
+
+// loggedCmd returns the command to replay for cmd. Connection changes are not
+// replayed, players have to reconnect after a restart anyway, and an expired
+// grace is replayed as the leave it resulted in.
+func loggedCmd(cmd RoomCmd) (RoomCmd, bool) {
+	switch cmd.Type {
+	case Disconnected, Reconnected:
+		return cmd, false
+	case GraceExpired:
+		return RoomCmd{Type: Leave, PlayerId: cmd.PlayerId, Seed: cmd.Seed, Time: cmd.Time}, true
+	}
+	return cmd, true
+}
+
+func (o *roomOutbox) deliver() {
+	o.mutex.Lock()
+	sends := o.sends
+	o.sends = nil
+	o.mutex.Unlock()
+	for _, send := range sends {
+		send()
+	}
+}
+
+func (o *roomOutbox) snapshotDue() bool {
+	return o.logging && o.sinceSnapshot >= SnapshotInterval
+}
+
+func (o *roomOutbox) checkpoint(room *entities.Room, game *Game, timer *phaseTimer) {
+	state, err := json.Marshal(roomState{Room: *room, Game: game.state(), Timer: timer.state(), Time: timer.clock.Now()})
+	if err != nil {
+		log.Error().Err(err).Msg("Impossible to encode the room state")
+		return
+	}
+	tx := database.Db.Create(&entities.RoomCheckpoint{RoomId: o.roomId, Seq: o.seq, State: string(state)})
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Str("room", o.roomId.String()).Msg("Impossible to save the room checkpoint")
+		return
+	}
+	o.sinceSnapshot = 0
+}
+
+func latestCheckpoint(roomId uuid.UUID) (*entities.RoomCheckpoint, error) {
+	var checkpoint entities.RoomCheckpoint
+	tx := database.Db.Where("room_id = ?", roomId).Order("seq desc, id desc").First(&checkpoint)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &checkpoint, tx.Error
+}
+
+func getRoomLog(roomId uuid.UUID, after uint64, kind string) ([]entities.RoomLogEntry, error) {
+	var entries []entities.RoomLogEntry
+	tx := database.Db.Where("room_id = ? AND seq > ?", roomId, after)
+	if kind != "" {
+		tx = tx.Where("kind = ?", kind)
+	}
+	tx = tx.Order("seq").Find(&entries)
+	return entries, tx.Error
+}
+
+func getRoomLogRange(roomId uuid.UUID, first uint64, last uint64) ([]entities.RoomLogEntry, error) {
+	var entries []entities.RoomLogEntry
+	tx := database.Db.Where("room_id = ? AND seq BETWEEN ? AND ?", roomId, first, last).Order("seq").Find(&entries)
+	return entries, tx.Error
+}
+
+func lastSeq(roomId uuid.UUID) (uint64, error) {
+	var seq uint64
+	tx := database.Db.Model(&entities.RoomLogEntry{}).Where("room_id = ?", roomId).Select("coalesce(max(seq), 0)").Scan(&seq)
+	return seq, tx.Error
+}
+
+// roomClock gives every command of a room the time it was received at, so a
+// replay sees the same times, and arms no timer while replaying.
+type roomClock struct {
+	clock     Clock
+	now       time.Time
+	replaying bool
+}
+
+type noopTimer struct{}
+
+func (noopTimer) Stop() bool {
+	return false
+}
+
+func (c *roomClock) Now() time.Time {
+	return c.now
+}
+
+func (c *roomClock) AfterFunc(duration time.Duration, f func()) Timer {
+	if c.replaying {
+		return noopTimer{}
+	}
+	return c.clock.AfterFunc(duration, f)
+}
+
+// recoverRoom rebuilds the room goroutine state from its latest checkpoint
+// and the commands logged after it. A room without checkpoint starts over
+// from the lobby.
+func recoverRoom(room *entities.Room, game *Game, timer *phaseTimer, graces *graceTimers, clock *roomClock) {
+	checkpoint, err := latestCheckpoint(room.ID)
+	if err == nil && checkpoint == nil {
+		room.State = RoomStateWaiting
+		room.PlayersReady = nil
+		database.Db.Save(room)
+		game.out.seq, err = lastSeq(room.ID)
+		if err != nil {
+			log.Error().Err(err).Str("room", room.ID.String()).Msg("Impossible to read the room log")
+		}
+		game.out.checkpoint(room, game, timer)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("room", room.ID.String()).Msg("Impossible to load the room checkpoint")
+		return
+	}
+
+	var state roomState
+	if err := json.Unmarshal([]byte(checkpoint.State), &state); err != nil {
+		log.Error().Err(err).Str("room", room.ID.String()).Msg("Impossible to decode the room checkpoint")
+		return
+	}
+
+	clock.replaying = true
+	game.out.replaying = true
+	clock.now = state.Time
+	players := room.Players
+	*room = state.Room
+	game.restore(state.Game)
+	timer.restore(state.Timer)
+
+	commands, err := getRoomLog(room.ID, checkpoint.Seq, LogKindCommand)
+	if err != nil {
+		log.Error().Err(err).Str("room", room.ID.String()).Msg("Impossible to read the room log")
+	}
+	last := state.Time
+	for _, entry := range commands {
+		var cmd RoomCmd
+		if err := json.Unmarshal([]byte(entry.Payload), &cmd); err != nil {
+			log.Error().Err(err).Uint64("seq", entry.Seq).Msg("Impossible to decode the logged room cmd")
+			continue
+		}
+		clock.now = cmd.Time
+		game.out.seq = entry.Seq - 1
+		game.random.Seed(cmd.Seed)
+		if err := handleRoomCmd(cmd, room, game, timer, graces); err != nil {
+			log.Warn().Err(err).Uint64("seq", entry.Seq).Msg("Logged room cmd rejected on replay")
+		}
+		game.step += 1
+		last = cmd.Time
+	}
+
+	game.out.seq, err = lastSeq(room.ID)
+	if err != nil {
+		log.Error().Err(err).Str("room", room.ID.String()).Msg("Impossible to read the room log")
+	}
+	clock.replaying = false
+	game.out.replaying = false
+	clock.now = clock.clock.Now()
+	timer.rearm(clock.now, last)
+
+	// Players left in the database are the source of truth for membership,
+	// everyone still there has to reconnect within the grace period.
+	room.Players = players
+	database.Db.Save(room)
+	for _, player := range room.Players {
+		graces.start(player.ID, seconds(room.Settings.ReconnectGrace))
+	}
+	if len(commands) > 0 {
+		game.out.checkpoint(room, game, timer)
+	}
+}
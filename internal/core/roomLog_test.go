@@ -0,0 +1,80 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"maps"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"testing"
+)
+
+func wordIds(words []entities.Word) []uint {
+	ids := make([]uint, 0, len(words))
+	for _, word := range words {
+		ids = append(ids, word.ID)
+	}
+	return ids
+}
+
+func (p testPlayer) snapshot(t *testing.T) PlayerEvent {
+	if _, err := ReconnectPlayer(p.ID, p.connection.Id); err != nil {
+		t.Fatal(err)
+	}
+	event, err := p.waitFor(RoomSnapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// restartRoom starts a second room goroutine from what the first one left in
+// the database, as after a crash. The first one no longer gets commands.
+func restartRoom(t *testing.T, roomId uuid.UUID) {
+	room, err := getRoom(roomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Settings = withDefaultSettings(room.Settings)
+	c := make(chan RoomCmd)
+	roomsMutex.Lock()
+	roomsIndex[roomId] = c
+	roomsMutex.Unlock()
+	go roomCycle(room, c, RoomClock)
+}
+
+func TestRestartRebuildsTheTurnInProgress(t *testing.T) {
+	host := newTestPlayer(t, "host")
+	guest := newTestPlayer(t, "guest")
+	roomId := newTestRoom(t, entities.RoomSettings{MinPlayers: 2, Turns: 2, HandSize: 4}, host, guest)
+
+	for _, player := range []testPlayer{host, guest} {
+		if err := SendRoomCmd(roomId, RoomCmd{Type: PlayerReady, PlayerId: player.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	started, err := host.waitFor(TurnStarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards := wordIds(started.Cards)[:started.Phrase.PlaceholdersAmount]
+	if err := SendRoomCmd(roomId, RoomCmd{Type: PlayerCardsSelected, PlayerId: host.ID, Cards: cards}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := host.snapshot(t)
+	restartRoom(t, roomId)
+	after := host.snapshot(t)
+
+	if !slices.Equal(wordIds(after.Cards), wordIds(before.Cards)) {
+		t.Fatalf("hand rebuilt as %v, was %v", wordIds(after.Cards), wordIds(before.Cards))
+	}
+	if after.Phrase.ID != before.Phrase.ID {
+		t.Fatalf("phrase rebuilt as %d, was %d", after.Phrase.ID, before.Phrase.ID)
+	}
+	if !maps.Equal(after.Trends, before.Trends) {
+		t.Fatalf("trends rebuilt as %v, were %v", after.Trends, before.Trends)
+	}
+	if !slices.Equal(after.PlayersCards[host.ID], cards) {
+		t.Fatalf("selection rebuilt as %v, was %v", after.PlayersCards[host.ID], cards)
+	}
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testEventTimeout = 5 * time.Second
+
+type testPlayer struct {
+	entities.Player
+	connection *Connection
+}
+
+func newTestPlayer(t *testing.T, name string) testPlayer {
+	player, err := AddPlayer(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection, err := AddPlayerConnection(player.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		RemovePlayerConnection(connection)
+	})
+	return testPlayer{Player: player, connection: connection}
+}
+
+// waitFor skips events until one of eventType is received.
+func (p testPlayer) waitFor(eventType uint) (PlayerEvent, error) {
+	timeout := time.After(testEventTimeout)
+	for {
+		select {
+		case event := <-p.connection.Events:
+			if event.Type == eventType {
+				return event, nil
+			}
+		case <-timeout:
+			return PlayerEvent{}, fmt.Errorf("player %s did not receive event %d", p.Name, eventType)
+		}
+	}
+}
+
+func newTestRoom(t *testing.T, settings entities.RoomSettings, players ...testPlayer) uuid.UUID {
+	roomId, err := CreateRoom(players[0].ID, t.Name(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, player := range players {
+		if err := JoinRoom(player.ID, roomId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return roomId
+}
+
+// TestRoomsPlayInParallel deals more cards than one words deck holds across
+// rooms playing at the same time, a deck shared between rooms would run out
+// and deal short hands.
+func TestRoomsPlayInParallel(t *testing.T) {
+	const rooms = 8
+	settings := entities.RoomSettings{MinPlayers: 2, Turns: 1, HandSize: 4}
+
+	words, err := GetWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rooms*2*int(settings.HandSize) <= len(words) {
+		t.Fatalf("%d words are enough for every room, the test needs more rooms", len(words))
+	}
+
+	type table struct {
+		roomId uuid.UUID
+		host   testPlayer
+		guest  testPlayer
+	}
+	tables := make([]table, 0, rooms)
+	for i := 0; i < rooms; i++ {
+		host := newTestPlayer(t, fmt.Sprintf("host %d", i))
+		guest := newTestPlayer(t, fmt.Sprintf("guest %d", i))
+		tables = append(tables, table{roomId: newTestRoom(t, settings, host, guest), host: host, guest: guest})
+	}
+
+	var dealt sync.WaitGroup
+	dealt.Add(rooms)
+	errs := make(chan error, rooms)
+	for _, table := range tables {
+		go func(roomId uuid.UUID, host testPlayer, guest testPlayer) {
+			errs <- playTurn(roomId, host, guest, settings.HandSize, &dealt)
+		}(table.roomId, table.host, table.guest)
+	}
+	for i := 0; i < rooms; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func playTurn(roomId uuid.UUID, host testPlayer, guest testPlayer, handSize uint, dealt *sync.WaitGroup) error {
+	dealtOnce := sync.OnceFunc(dealt.Done)
+	defer dealtOnce()
+
+	players := []testPlayer{host, guest}
+	for _, player := range players {
+		if err := SendRoomCmd(roomId, RoomCmd{Type: PlayerReady, PlayerId: player.ID}); err != nil {
+			return err
+		}
+	}
+
+	hands := make(map[uuid.UUID][]entities.Word)
+	seen := make(map[uint]bool)
+	var phrase entities.Phrase
+	for _, player := range players {
+		event, err := player.waitFor(TurnStarted)
+		if err != nil {
+			return err
+		}
+		if uint(len(event.Cards)) != handSize {
+			return fmt.Errorf("room %s dealt %d cards to %s, expected %d", roomId, len(event.Cards), player.Name, handSize)
+		}
+		for _, word := range event.Cards {
+			if seen[word.ID] {
+				return fmt.Errorf("room %s dealt word %d twice", roomId, word.ID)
+			}
+			seen[word.ID] = true
+		}
+		hands[player.ID] = event.Cards
+		phrase = event.Phrase
+	}
+
+	// Every room holds its hands before any card goes back to a deck.
+	dealtOnce()
+	dealt.Wait()
+
+	for _, player := range players {
+		cards := make([]uint, 0, phrase.PlaceholdersAmount)
+		for _, word := range hands[player.ID][:phrase.PlaceholdersAmount] {
+			cards = append(cards, word.ID)
+		}
+		if err := SendRoomCmd(roomId, RoomCmd{Type: PlayerCardsSelected, PlayerId: player.ID, Cards: cards}); err != nil {
+			return err
+		}
+	}
+	if _, err := host.waitFor(AllPlayerSelectedCards); err != nil {
+		return err
+	}
+
+	for i, player := range players {
+		other := players[1-i]
+		ballot := Ballot{Likes: map[uuid.UUID]bool{other.ID: true}}
+		if err := SendRoomCmd(roomId, RoomCmd{Type: PlayerRatedOtherCards, PlayerId: player.ID, Ballot: ballot}); err != nil {
+			return err
+		}
+	}
+
+	event, err := host.waitFor(TurnEnded)
+	if err != nil {
+		return err
+	}
+	if len(event.Leaderboards) != len(players) {
+		return fmt.Errorf("room %s leaderboard has %d players, expected %d", roomId, len(event.Leaderboards), len(players))
+	}
+	for _, player := range players {
+		if event.Leaderboards[player.ID] != event.Result[player.ID] {
+			return fmt.Errorf("room %s leaderboard of %s is %d after a single turn scoring %d", roomId, player.Name, event.Leaderboards[player.ID], event.Result[player.ID])
+		}
+	}
+	return nil
+}
+
+func TestRoomsWithTheSameSeedDealTheSame(t *testing.T) {
+	AllowFixedSeed = true
+	defer func() {
+		AllowFixedSeed = false
+	}()
+	settings := entities.RoomSettings{MinPlayers: 2, Turns: 1, HandSize: 4, Seed: 42}
+
+	type deal struct {
+		phrase uint
+		hands  [][]uint
+	}
+	deals := make([]deal, 0, 2)
+	for i := 0; i < 2; i++ {
+		host := newTestPlayer(t, fmt.Sprintf("host %d", i))
+		guest := newTestPlayer(t, fmt.Sprintf("guest %d", i))
+		roomId := newTestRoom(t, settings, host, guest)
+
+		dealt := deal{}
+		for _, player := range []testPlayer{host, guest} {
+			if err := SendRoomCmd(roomId, RoomCmd{Type: PlayerReady, PlayerId: player.ID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, player := range []testPlayer{host, guest} {
+			event, err := player.waitFor(TurnStarted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dealt.phrase = event.Phrase.ID
+			dealt.hands = append(dealt.hands, wordIds(event.Cards))
+		}
+		deals = append(deals, dealt)
+	}
+
+	if deals[0].phrase != deals[1].phrase {
+		t.Fatalf("phrases %d and %d dealt from the same seed", deals[0].phrase, deals[1].phrase)
+	}
+	for seat := range deals[0].hands {
+		if !slices.Equal(deals[0].hands[seat], deals[1].hands[seat]) {
+			t.Fatalf("seat %d dealt %v and %v from the same seed", seat, deals[0].hands[seat], deals[1].hands[seat])
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"pitch-perfect-server/internal/apperr"
+)
+
+const (
+	RuleTrend              = "trend"
+	RuleReviewWinner       = "review_winner"
+	RuleCategoryCombo      = "category_combo"
+	RuleDiminishingReturns = "diminishing_returns"
+	RuleVotes              = "votes"
+)
+
+const DefaultScorer = "default"
+
+const ComboBonusPerCard = 2
+
+type RuleScore struct {
+	Rule   string
+	Points int
+}
+
+type CardScore struct {
+	Card     uint
+	Category uint
+	Points   uint
+	Rules    []RuleScore
+}
+
+// PlayerScore Total is what the pitch earned this turn, Balance adds the side
+// game wagers to it and is what moves the leaderboard.
+type PlayerScore struct {
+	Cards   []CardScore
+	Bonuses []RuleScore
+	Wagers  []RuleScore
+	Total   uint
+	Balance int
+}
+
+type ScoreInput struct {
+	Trends       map[uint]uint
+	PlayerTrends map[uuid.UUID]map[uint]uint
+	WordCategory map[uint]uint
+	Cards        map[uuid.UUID][]uint
+	Votes        map[uuid.UUID]uint
+	Multipliers  map[uuid.UUID]uint
+}
+
+type Scorer interface {
+	Score(input ScoreInput) map[uuid.UUID]PlayerScore
+}
+
+var scorers = map[string]Scorer{
+	DefaultScorer:  defaultScorer{},
+	"combo":        comboScorer{},
+	"diminishing":  diminishingScorer{},
+	"proportional": proportionalScorer{},
+}
+
+func GetScorer(name string) (Scorer, error) {
+	scorer, ok := scorers[name]
+	if !ok {
+		return nil, apperr.New(apperr.InvalidSettings, apperr.Params{"field": "Scoring", "value": name}, "unknown scoring rules %s", name)
+	}
+	return scorer, nil
+}
+
+func (s *CardScore) apply(rule string, points int) {
+	if points == 0 {
+		return
+	}
+	s.Rules = append(s.Rules, RuleScore{Rule: rule, Points: points})
+	s.Points = uint(max(int(s.Points)+points, 0))
+}
+
+func (s *PlayerScore) total() {
+	var total int
+	for _, card := range s.Cards {
+		total += int(card.Points)
+	}
+	for _, bonus := range s.Bonuses {
+		total += bonus.Points
+	}
+	s.Total = uint(max(total, 0))
+
+	s.Balance = int(s.Total)
+	for _, wager := range s.Wagers {
+		s.Balance += wager.Points
+	}
+}
+
+// trendsFor returns the turn trends moved by the breaking news the player had
+// seen when submitting, or the turn trends alone.
+func (input ScoreInput) trendsFor(player uuid.UUID) map[uint]uint {
+	if trends, ok := input.PlayerTrends[player]; ok {
+		return trends
+	}
+	return input.Trends
+}
+
+func trendCardScores(input ScoreInput, player uuid.UUID, cards []uint) []CardScore {
+	trends := input.trendsFor(player)
+	scores := make([]CardScore, 0, len(cards))
+	for _, card := range cards {
+		category := input.WordCategory[card]
+		score := CardScore{Card: card, Category: category}
+		score.apply(RuleTrend, int(trends[category]+1))
+		scores = append(scores, score)
+	}
+	return scores
+}
+
+func applyWinnerMultiplier(input ScoreInput, player uuid.UUID, scores []CardScore) {
+	multiplier := input.Multipliers[player]
+	if multiplier < 2 {
+		return
+	}
+	for i := range scores {
+		scores[i].apply(RuleReviewWinner, int(scores[i].Points*(multiplier-1)))
+	}
+}
+
+// defaultScorer gives every card its category trend plus one, multiplied for
+// the review winners.
+type defaultScorer struct{}
+
+func (defaultScorer) Score(input ScoreInput) map[uuid.UUID]PlayerScore {
+	result := make(map[uuid.UUID]PlayerScore)
+	for player, cards := range input.Cards {
+		score := PlayerScore{Cards: trendCardScores(input, player, cards)}
+		applyWinnerMultiplier(input, player, score.Cards)
+		score.total()
+		result[player] = score
+	}
+	return result
+}
+
+// comboScorer adds a bonus when every card of a multi card pitch shares the
+// same category.
+type comboScorer struct{}
+
+func (comboScorer) Score(input ScoreInput) map[uuid.UUID]PlayerScore {
+	result := defaultScorer{}.Score(input)
+	for player, score := range result {
+		if len(score.Cards) < 2 {
+			continue
+		}
+
+		combo := true
+		for _, card := range score.Cards {
+			combo = combo && card.Category == score.Cards[0].Category
+		}
+
+		if combo {
+			score.Bonuses = append(score.Bonuses, RuleScore{Rule: RuleCategoryCombo, Points: ComboBonusPerCard * len(score.Cards)})
+			score.total()
+			result[player] = score
+		}
+	}
+	return result
+}
+
+// diminishingScorer halves the trend points of every further card of a
+// category already played in the same pitch.
+type diminishingScorer struct{}
+
+func (diminishingScorer) Score(input ScoreInput) map[uuid.UUID]PlayerScore {
+	result := make(map[uuid.UUID]PlayerScore)
+	for player, cards := range input.Cards {
+		score := PlayerScore{Cards: trendCardScores(input, player, cards)}
+		played := make(map[uint]uint)
+		for i := range score.Cards {
+			repeats := played[score.Cards[i].Category]
+			played[score.Cards[i].Category] += 1
+			if repeats > 0 {
+				reduced := score.Cards[i].Points >> repeats
+				score.Cards[i].apply(RuleDiminishingReturns, int(reduced)-int(score.Cards[i].Points))
+			}
+		}
+		applyWinnerMultiplier(input, player, score.Cards)
+		score.total()
+		result[player] = score
+	}
+	return result
+}
+
+// proportionalScorer replaces the winner multipliers with the trend points
+// earned again for every vote received.
+type proportionalScorer struct{}
+
+func (proportionalScorer) Score(input ScoreInput) map[uuid.UUID]PlayerScore {
+	result := make(map[uuid.UUID]PlayerScore)
+	for player, cards := range input.Cards {
+		score := PlayerScore{Cards: trendCardScores(input, player, cards)}
+		votes := input.Votes[player]
+		for i := range score.Cards {
+			score.Cards[i].apply(RuleVotes, int(score.Cards[i].Points*votes))
+		}
+		score.total()
+		result[player] = score
+	}
+	return result
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+	"time"
+)
+
+const (
+	MaxTurns    = 20
+	MaxHandSize = 10
+	MaxPlayers  = 16
+	MaxTimeout  = 600
+
+	MaxWinnerMultiplier = 10
+	MaxReviewBudget     = 100
+	MaxSwaps            = 5
+	MaxSwapCost         = 10
+	MaxShocks           = 5
+	MaxStake            = 50
+	MaxForecastCost     = 20
+)
+
+// AllowFixedSeed lets rooms pick the seed of their games, it is meant for
+// debugging and reproducing bug reports.
+var AllowFixedSeed = false
+
+func DefaultRoomSettings() entities.RoomSettings {
+	return entities.RoomSettings{
+		Turns:            4,
+		HandSize:         4,
+		MinPlayers:       1,
+		MaxPlayers:       8,
+		ReadyTimeout:     15,
+		SelectionTimeout: 60,
+		ReviewTimeout:    60,
+		WinnerMultiplier: 2,
+		ReconnectGrace:   30,
+		Scoring:          DefaultScorer,
+		TiePolicy:        TiePolicyShare,
+		Winners:          1,
+		ReviewMode:       ReviewModeLikes,
+		ReviewBudget:     10,
+		ShockMagnitude:   1,
+		MaxStake:         5,
+		ForecastCost:     2,
+		RematchTimeout:   30,
+	}
+}
+
+func withDefaultSettings(settings entities.RoomSettings) entities.RoomSettings {
+	defaults := DefaultRoomSettings()
+	if settings.Turns == 0 {
+		settings.Turns = defaults.Turns
+	}
+	if settings.HandSize == 0 {
+		settings.HandSize = defaults.HandSize
+	}
+	if settings.MinPlayers == 0 {
+		settings.MinPlayers = defaults.MinPlayers
+	}
+	if settings.MaxPlayers == 0 {
+		settings.MaxPlayers = defaults.MaxPlayers
+	}
+	if settings.ReadyTimeout == 0 {
+		settings.ReadyTimeout = defaults.ReadyTimeout
+	}
+	if settings.SelectionTimeout == 0 {
+		settings.SelectionTimeout = defaults.SelectionTimeout
+	}
+	if settings.ReviewTimeout == 0 {
+		settings.ReviewTimeout = defaults.ReviewTimeout
+	}
+	if settings.WinnerMultiplier == 0 {
+		settings.WinnerMultiplier = defaults.WinnerMultiplier
+	}
+	if settings.ReconnectGrace == 0 {
+		settings.ReconnectGrace = defaults.ReconnectGrace
+	}
+	if len(settings.Scoring) == 0 {
+		settings.Scoring = defaults.Scoring
+	}
+	if len(settings.TiePolicy) == 0 {
+		settings.TiePolicy = defaults.TiePolicy
+	}
+	if settings.Winners == 0 {
+		settings.Winners = defaults.Winners
+	}
+	if len(settings.ReviewMode) == 0 {
+		settings.ReviewMode = defaults.ReviewMode
+	}
+	if settings.ReviewBudget == 0 {
+		settings.ReviewBudget = defaults.ReviewBudget
+	}
+	if settings.ShockMagnitude == 0 {
+		settings.ShockMagnitude = defaults.ShockMagnitude
+	}
+	if settings.MaxStake == 0 {
+		settings.MaxStake = defaults.MaxStake
+	}
+	if settings.ForecastCost == 0 {
+		settings.ForecastCost = defaults.ForecastCost
+	}
+	if settings.RematchTimeout == 0 {
+		settings.RematchTimeout = defaults.RematchTimeout
+	}
+	return settings
+}
+
+func ValidateRoomSettings(settings entities.RoomSettings) error {
+	if err := checkSettingRange("Turns", settings.Turns, 1, MaxTurns); err != nil {
+		return err
+	}
+	if err := checkSettingRange("HandSize", settings.HandSize, 1, MaxHandSize); err != nil {
+		return err
+	}
+	if err := checkSettingRange("MinPlayers", settings.MinPlayers, 1, MaxPlayers); err != nil {
+		return err
+	}
+	if err := checkSettingRange("MaxPlayers", settings.MaxPlayers, settings.MinPlayers, MaxPlayers); err != nil {
+		return err
+	}
+	if err := checkSettingRange("ReadyTimeout", settings.ReadyTimeout, 1, MaxTimeout); err != nil {
+		return err
+	}
+	if err := checkSettingRange("SelectionTimeout", settings.SelectionTimeout, 1, MaxTimeout); err != nil {
+		return err
+	}
+	if err := checkSettingRange("ReviewTimeout", settings.ReviewTimeout, 1, MaxTimeout); err != nil {
+		return err
+	}
+	if err := checkSettingRange("WinnerMultiplier", settings.WinnerMultiplier, 1, MaxWinnerMultiplier); err != nil {
+		return err
+	}
+	if err := checkSettingRange("ReconnectGrace", settings.ReconnectGrace, 1, MaxTimeout); err != nil {
+		return err
+	}
+	if _, err := GetScorer(settings.Scoring); err != nil {
+		return err
+	}
+	if err := validateTiePolicy(settings.TiePolicy); err != nil {
+		return err
+	}
+	if err := checkSettingRange("Winners", settings.Winners, 1, settings.MaxPlayers); err != nil {
+		return err
+	}
+	if _, err := GetReviewMode(settings.ReviewMode); err != nil {
+		return err
+	}
+	if err := checkSettingRange("ReviewBudget", settings.ReviewBudget, 1, MaxReviewBudget); err != nil {
+		return err
+	}
+	if err := checkSettingRange("MulliganCards", settings.MulliganCards, 0, settings.HandSize); err != nil {
+		return err
+	}
+	if err := checkSettingRange("Swaps", settings.Swaps, 0, MaxSwaps); err != nil {
+		return err
+	}
+	if err := checkSettingRange("SwapCost", settings.SwapCost, 0, MaxSwapCost); err != nil {
+		return err
+	}
+	if err := checkSettingRange("Shocks", settings.Shocks, 0, MaxShocks); err != nil {
+		return err
+	}
+	if err := checkSettingRange("ShockMagnitude", settings.ShockMagnitude, 1, Trends.Levels); err != nil {
+		return err
+	}
+	if err := checkSettingRange("MaxStake", settings.MaxStake, 1, MaxStake); err != nil {
+		return err
+	}
+	if err := checkSettingRange("ForecastCost", settings.ForecastCost, 1, MaxForecastCost); err != nil {
+		return err
+	}
+	if err := checkSettingRange("RematchTimeout", settings.RematchTimeout, 1, MaxTimeout); err != nil {
+		return err
+	}
+	if settings.Seed != 0 && !AllowFixedSeed {
+		return apperr.New(apperr.InvalidSettings, apperr.Params{"field": "Seed"}, "fixed seeds are disabled on this server")
+	}
+	return validateAgainstDecks(settings)
+}
+
+// validateAgainstDecks checks the settings can be played with the configured
+// cards: every hand can fill any phrase and a full table can be dealt.
+func validateAgainstDecks(settings entities.RoomSettings) error {
+	phrases, err := GetPhrases()
+	if err != nil {
+		return err
+	}
+	var placeholders uint = 1
+	for _, phrase := range phrases {
+		placeholders = max(placeholders, phrase.PlaceholdersAmount)
+	}
+	if err := checkSettingRange("HandSize", settings.HandSize, placeholders, MaxHandSize); err != nil {
+		return err
+	}
+
+	words, err := GetWords()
+	if err != nil {
+		return err
+	}
+	if err := checkSettingRange("MaxPlayers", settings.MaxPlayers, settings.MinPlayers, uint(len(words))/settings.HandSize); err != nil {
+		return err
+	}
+
+	if settings.NoPhraseRepeats {
+		if err := checkSettingRange("Turns", settings.Turns, 1, uint(len(phrases))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSettingRange(field string, value uint, min uint, max uint) error {
+	if value < min || value > max {
+		return apperr.New(apperr.InvalidSettings, apperr.Params{"field": field, "min": min, "max": max}, "%s must be between %d and %d", field, min, max)
+	}
+	return nil
+}
+
+func seconds(value uint) time.Duration {
+	return time.Duration(value) * time.Second
+}
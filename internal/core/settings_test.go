@@ -0,0 +1,23 @@
+package core
+
+import (
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+	"testing"
+)
+
+func TestSettingsMustFitTheDecks(t *testing.T) {
+	for name, settings := range map[string]entities.RoomSettings{
+		"hand smaller than a phrase": {HandSize: 2},
+		"table larger than the deck": {HandSize: 4, MaxPlayers: 16},
+	} {
+		err := ValidateRoomSettings(withDefaultSettings(settings))
+		if err == nil || apperr.From(err).Code != apperr.InvalidSettings {
+			t.Errorf("%s: expected invalid settings, got %v", name, err)
+		}
+	}
+
+	if err := ValidateRoomSettings(DefaultRoomSettings()); err != nil {
+		t.Errorf("default settings rejected: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	AfterFunc(duration time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(duration time.Duration, f func()) Timer {
+	return time.AfterFunc(duration, f)
+}
+
+var RoomClock Clock = realClock{}
+
+// phaseTimer keeps at most one pending timeout per room. Every start or stop
+// bumps the generation, so a timeout that already fired for a previous phase
+// is recognised as stale once it reaches the room goroutine.
+type phaseTimer struct {
+	clock      Clock
+	c          chan RoomCmd
+	generation uint
+	timer      Timer
+	pending    RoomCmd
+	scheduled  []scheduledCmd
+	deadline   time.Time
+}
+
+type scheduledCmd struct {
+	Cmd   RoomCmd
+	At    time.Time
+	timer Timer
+}
+
+// timerState is what a room snapshot keeps of its phase timer, the timers
+// themselves are armed again from it once the room is restored.
+type timerState struct {
+	Generation uint
+	Active     bool
+	Pending    RoomCmd
+	Scheduled  []scheduledCmd
+	Deadline   time.Time
+}
+
+func newPhaseTimer(clock Clock, c chan RoomCmd) *phaseTimer {
+	return &phaseTimer{clock: clock, c: c}
+}
+
+func (t *phaseTimer) start(cmd RoomCmd, duration time.Duration) {
+	t.stop()
+	cmd.Generation = t.generation
+	t.pending = cmd
+	t.deadline = t.clock.Now().Add(duration)
+	t.timer = t.fire(cmd, duration)
+}
+
+func (t *phaseTimer) fire(cmd RoomCmd, duration time.Duration) Timer {
+	c := t.c
+	return t.clock.AfterFunc(duration, func() {
+		c <- cmd
+	})
+}
+
+// schedule fires cmd during the current phase without replacing its timeout,
+// it is cancelled along with the phase.
+func (t *phaseTimer) schedule(cmd RoomCmd, duration time.Duration) {
+	cmd.Generation = t.generation
+	at := t.clock.Now().Add(duration)
+	t.scheduled = append(t.scheduled, scheduledCmd{Cmd: cmd, At: at, timer: t.fire(cmd, duration)})
+}
+
+func (t *phaseTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	for _, scheduled := range t.scheduled {
+		scheduled.timer.Stop()
+	}
+	t.scheduled = nil
+	t.pending = RoomCmd{}
+	t.generation += 1
+	t.deadline = time.Time{}
+}
+
+func (t *phaseTimer) state() timerState {
+	return timerState{Generation: t.generation, Active: t.timer != nil, Pending: t.pending, Scheduled: t.scheduled, Deadline: t.deadline}
+}
+
+func (t *phaseTimer) restore(state timerState) {
+	t.stop()
+	t.generation = state.Generation
+	t.pending = state.Pending
+	t.deadline = state.Deadline
+	t.scheduled = state.Scheduled
+	for i, scheduled := range t.scheduled {
+		t.scheduled[i].timer = t.fire(scheduled.Cmd, 0)
+	}
+	if state.Active {
+		t.timer = t.fire(t.pending, 0)
+	}
+}
+
+// rearm arms again the timeout and the scheduled commands of a restored
+// phase, for what is left of them at now. Scheduled commands due before last
+// are considered already handled.
+func (t *phaseTimer) rearm(now time.Time, last time.Time) {
+	if t.timer != nil {
+		t.timer = t.fire(t.pending, max(t.deadline.Sub(now), 0))
+	}
+
+	scheduled := make([]scheduledCmd, 0, len(t.scheduled))
+	for _, cmd := range t.scheduled {
+		if !cmd.At.After(last) {
+			continue
+		}
+		cmd.timer = t.fire(cmd.Cmd, max(cmd.At.Sub(now), 0))
+		scheduled = append(scheduled, cmd)
+	}
+	t.scheduled = scheduled
+}
+
+func (t *phaseTimer) isCurrent(cmd RoomCmd) bool {
+	return cmd.Generation == t.generation && t.timer != nil
+}
+
+type graceTimer struct {
+	timer      Timer
+	generation uint
+}
+
+type graceTimers struct {
+	clock      Clock
+	c          chan RoomCmd
+	generation uint
+	timers     map[uuid.UUID]graceTimer
+}
+
+func newGraceTimers(clock Clock, c chan RoomCmd) *graceTimers {
+	return &graceTimers{clock: clock, c: c, timers: make(map[uuid.UUID]graceTimer)}
+}
+
+func (t *graceTimers) start(playerId uuid.UUID, duration time.Duration) {
+	t.cancel(playerId)
+	t.generation += 1
+	cmd := RoomCmd{Type: GraceExpired, PlayerId: playerId, Generation: t.generation}
+	c := t.c
+	timer := t.clock.AfterFunc(duration, func() {
+		c <- cmd
+	})
+	t.timers[playerId] = graceTimer{timer: timer, generation: t.generation}
+}
+
+func (t *graceTimers) cancel(playerId uuid.UUID) bool {
+	grace, ok := t.timers[playerId]
+	if !ok {
+		return false
+	}
+	grace.timer.Stop()
+	delete(t.timers, playerId)
+	return true
+}
+
+func (t *graceTimers) isCurrent(cmd RoomCmd) bool {
+	grace, ok := t.timers[cmd.PlayerId]
+	return ok && grace.generation == cmd.Generation
+}
+
+func isTimeoutCmd(cmd RoomCmd) bool {
+	switch cmd.Type {
+	case PlayerReadyTimeout, PlayerCardsSelectedTimeout, PlayerRatedOtherCardsTimeout, TrendShock, RematchTimeout:
+		return true
+	}
+	return false
+}
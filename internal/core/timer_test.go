@@ -0,0 +1,137 @@
+package core
+
+import (
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves on Advance, which runs the
+// timers that became due in order.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	f     func()
+	done  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(duration time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &fakeTimer{clock: c, at: c.now.Add(duration), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := !t.done
+	t.done = true
+	return active
+}
+
+func (c *fakeClock) Advance(duration time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(duration)
+	due := make([]*fakeTimer, 0)
+	for _, timer := range c.timers {
+		if !timer.done && !timer.at.After(c.now) {
+			timer.done = true
+			due = append(due, timer)
+		}
+	}
+	c.mutex.Unlock()
+
+	slices.SortStableFunc(due, func(a *fakeTimer, b *fakeTimer) int { return a.at.Compare(b.at) })
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func TestStaleReadyTimeoutIsNotCurrent(t *testing.T) {
+	clock := newFakeClock()
+	c := make(chan RoomCmd, 2)
+	timer := newPhaseTimer(clock, c)
+
+	timer.start(RoomCmd{Type: PlayerReadyTimeout}, 15*time.Second)
+	clock.Advance(15 * time.Second)
+	stale := <-c
+
+	// The game started before the room goroutine got to the timeout.
+	timer.start(RoomCmd{Type: PlayerCardsSelectedTimeout}, 60*time.Second)
+	if timer.isCurrent(stale) {
+		t.Fatal("ready timeout of a previous phase is still current")
+	}
+
+	clock.Advance(60 * time.Second)
+	current := <-c
+	if current.Type != PlayerCardsSelectedTimeout || !timer.isCurrent(current) {
+		t.Fatalf("selection timeout %+v is not current", current)
+	}
+}
+
+func TestStoppedTimerDoesNotFire(t *testing.T) {
+	clock := newFakeClock()
+	c := make(chan RoomCmd, 1)
+	timer := newPhaseTimer(clock, c)
+
+	timer.start(RoomCmd{Type: PlayerReadyTimeout}, 15*time.Second)
+	timer.stop()
+	clock.Advance(time.Minute)
+
+	select {
+	case cmd := <-c:
+		t.Fatalf("stopped timer fired %+v", cmd)
+	default:
+	}
+}
+
+func TestReadyTimeoutStartsGameOnVirtualTime(t *testing.T) {
+	clock := newFakeClock()
+	RoomClock = clock
+	defer func() {
+		RoomClock = realClock{}
+	}()
+
+	host := newTestPlayer(t, "host")
+	guest := newTestPlayer(t, "guest")
+	settings := entities.RoomSettings{MinPlayers: 1, ReadyTimeout: 15}
+	roomId := newTestRoom(t, settings, host, guest)
+
+	if err := SendRoomCmd(roomId, RoomCmd{Type: PlayerReady, PlayerId: host.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(14 * time.Second)
+	if err := SendRoomCmd(roomId, RoomCmd{Type: UpdateSettings, PlayerId: host.ID, Settings: withDefaultSettings(settings)}); err != nil {
+		t.Fatalf("room left the lobby before its ready timeout: %v", err)
+	}
+
+	clock.Advance(time.Second)
+	event, err := guest.waitFor(GameStarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !event.ServerTime.Equal(clock.Now()) {
+		t.Fatalf("game started at %v, expected the virtual %v", event.ServerTime, clock.Now())
+	}
+}
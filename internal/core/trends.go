@@ -0,0 +1,202 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"pitch-perfect-server/internal/entities"
+	"slices"
+	"time"
+)
+
+// TrendConfig describes how category trends evolve. Levels go from zero to
+// Levels-1, Initial weights the level every category starts from and row i of
+// Matrix weights the next level of a category currently at level i.
+type TrendConfig struct {
+	Levels  uint     `json:"levels"`
+	Initial []uint   `json:"initial"`
+	Matrix  [][]uint `json:"matrix"`
+}
+
+var Trends = DefaultTrendConfig()
+
+func DefaultTrendConfig() TrendConfig {
+	return TrendConfig{
+		Levels:  5,
+		Initial: []uint{1, 1, 1, 1, 1},
+		Matrix: [][]uint{
+			{30, 25, 20, 15, 10},
+			{10, 30, 25, 20, 15},
+			{15, 10, 30, 25, 20},
+			{20, 15, 10, 30, 25},
+			{25, 20, 15, 10, 30},
+		},
+	}
+}
+
+func (c TrendConfig) validate() error {
+	if c.Levels == 0 {
+		return fmt.Errorf("trends need at least one level")
+	}
+	if err := checkWeights("initial", c.Initial, c.Levels); err != nil {
+		return err
+	}
+	if uint(len(c.Matrix)) != c.Levels {
+		return fmt.Errorf("trends matrix has %d rows, expected %d", len(c.Matrix), c.Levels)
+	}
+	for i, row := range c.Matrix {
+		if err := checkWeights(fmt.Sprintf("matrix row %d", i), row, c.Levels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkWeights(name string, weights []uint, levels uint) error {
+	if uint(len(weights)) != levels {
+		return fmt.Errorf("trends %s has %d weights, expected %d", name, len(weights), levels)
+	}
+	var total uint
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("trends %s has no positive weight", name)
+	}
+	return nil
+}
+
+func validateCategories(categories []entities.Category, words []entities.Word) error {
+	if len(categories) == 0 {
+		return fmt.Errorf("no categories configured")
+	}
+	known := make(map[uint]bool)
+	for _, category := range categories {
+		known[category.ID] = true
+	}
+	for _, word := range words {
+		if !known[word.CategoryId] {
+			return fmt.Errorf("word %d references unknown category %d", word.ID, word.CategoryId)
+		}
+	}
+	return nil
+}
+
+type TrendEngine struct {
+	config TrendConfig
+	random *rand.Rand
+}
+
+func NewTrendEngine(config TrendConfig, random *rand.Rand) *TrendEngine {
+	return &TrendEngine{config: config, random: random}
+}
+
+// Initial and Next walk categories in id order so that the same seed always
+// gives the same trends.
+func (e *TrendEngine) Initial(categories []entities.Category) map[uint]uint {
+	ids := make([]uint, 0, len(categories))
+	for _, category := range categories {
+		ids = append(ids, category.ID)
+	}
+	slices.Sort(ids)
+
+	trends := make(map[uint]uint)
+	for _, id := range ids {
+		trends[id] = e.choose(e.config.Initial)
+	}
+	return trends
+}
+
+func (e *TrendEngine) Next(trends map[uint]uint) map[uint]uint {
+	categories := make([]uint, 0, len(trends))
+	for category := range trends {
+		categories = append(categories, category)
+	}
+	slices.Sort(categories)
+
+	next := make(map[uint]uint)
+	for _, category := range categories {
+		next[category] = e.choose(e.config.Matrix[min(trends[category], e.config.Levels-1)])
+	}
+	return next
+}
+
+func (e *TrendEngine) choose(weights []uint) uint {
+	var total uint
+	for _, weight := range weights {
+		total += weight
+	}
+
+	pick := uint(e.random.Int63n(int64(total)))
+	for level, weight := range weights {
+		if pick < weight {
+			return uint(level)
+		}
+		pick -= weight
+	}
+	return uint(len(weights) - 1)
+}
+
+// ShockConfig describes a kind of breaking news, Direction tells whether it
+// raises or lowers the trend of the category it hits and Weight how likely it
+// is compared to the other kinds.
+type ShockConfig struct {
+	Kind      string `json:"kind"`
+	Direction int    `json:"direction"`
+	Weight    uint   `json:"weight"`
+}
+
+var Shocks = DefaultShockConfig()
+
+func DefaultShockConfig() []ShockConfig {
+	return []ShockConfig{
+		{Kind: "boost", Direction: 1, Weight: 1},
+		{Kind: "crash", Direction: -1, Weight: 1},
+	}
+}
+
+func validateShocks(shocks []ShockConfig) error {
+	weights := make([]uint, 0, len(shocks))
+	for _, shock := range shocks {
+		if len(shock.Kind) == 0 || (shock.Direction != 1 && shock.Direction != -1) {
+			return fmt.Errorf("trend shocks need a kind and a direction of 1 or -1")
+		}
+		weights = append(weights, shock.Weight)
+	}
+	return checkWeights("shocks", weights, uint(len(shocks)))
+}
+
+type TrendShift struct {
+	Kind     string
+	Category uint
+	Delta    int
+}
+
+// Shock picks a kind of breaking news and a category, and returns the shift
+// moving that category trend by magnitude levels within the configured
+// bounds. trends is left as it is.
+func (e *TrendEngine) Shock(trends map[uint]uint, shocks []ShockConfig, magnitude uint) TrendShift {
+	weights := make([]uint, 0, len(shocks))
+	for _, shock := range shocks {
+		weights = append(weights, shock.Weight)
+	}
+	shock := shocks[e.choose(weights)]
+
+	categories := make([]uint, 0, len(trends))
+	for category := range trends {
+		categories = append(categories, category)
+	}
+	slices.Sort(categories)
+	category := categories[e.random.Intn(len(categories))]
+
+	level := int(trends[category]) + shock.Direction*int(magnitude)
+	level = max(0, min(level, int(e.config.Levels)-1))
+	return TrendShift{Kind: shock.Kind, Category: category, Delta: level - int(trends[category])}
+}
+
+func (e *TrendEngine) ShockDelays(count uint, window time.Duration) []time.Duration {
+	delays := make([]time.Duration, 0, count)
+	for i := uint(0); i < count; i++ {
+		delays = append(delays, time.Duration(e.random.Int63n(int64(window))))
+	}
+	return delays
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"maps"
+	"pitch-perfect-server/internal/apperr"
+	"pitch-perfect-server/internal/entities"
+	"sync"
+)
+
+const ViolationsWarningThreshold = 5
+
+var violations = make(map[uuid.UUID]uint)
+var violationsMutex sync.Mutex
+
+func (g *Game) validateSelection(cmd RoomCmd) error {
+	if _, ok := g.selectedCards[cmd.PlayerId]; ok {
+		return apperr.New(apperr.AlreadySubmitted, nil, "cards already selected for this turn")
+	}
+
+	if uint(len(cmd.Cards)) != g.phrase.PlaceholdersAmount {
+		params := apperr.Params{"expected": g.phrase.PlaceholdersAmount, "received": len(cmd.Cards)}
+		return apperr.New(apperr.InvalidCardCount, params, "expected %d cards, received %d", g.phrase.PlaceholdersAmount, len(cmd.Cards))
+	}
+
+	return g.checkCardsInHand(cmd.PlayerId, cmd.Cards)
+}
+
+func (g *Game) validateReview(cmd RoomCmd, settings entities.RoomSettings) error {
+	if _, ok := g.playersReview[cmd.PlayerId]; ok {
+		return apperr.New(apperr.AlreadySubmitted, nil, "review already submitted for this turn")
+	}
+
+	mode, err := GetReviewMode(settings.ReviewMode)
+	if err != nil {
+		return err
+	}
+
+	if err := mode.validate(cmd.Ballot, settings.ReviewBudget); err != nil {
+		return err
+	}
+
+	for _, target := range mode.targets(cmd.Ballot) {
+		if target == cmd.PlayerId {
+			return apperr.New(apperr.InvalidReviewTarget, apperr.Params{"target": target}, "players cannot review their own cards")
+		}
+
+		if _, ok := g.selectedCards[target]; !ok {
+			return apperr.New(apperr.InvalidReviewTarget, apperr.Params{"target": target}, "player %s did not submit any card", target.String())
+		}
+	}
+
+	return nil
+}
+
+func validateMembership(cmd RoomCmd, room *entities.Room) error {
+	switch cmd.Type {
+	case PlayerReady, PlayerCardsSelected, PlayerRatedOtherCards, PlayerMulligan, PlayerSwap, PlayerBet, PlayerForecast, Rematch:
+		if !isInRoom(room, cmd.PlayerId) {
+			return notInRoom(cmd.PlayerId, room.ID)
+		}
+	}
+	return nil
+}
+
+func isViolation(err error) bool {
+	switch apperr.From(err).Code {
+	case apperr.NotInRoom, apperr.CardNotInHand, apperr.DuplicateCard, apperr.InvalidCardCount,
+		apperr.AlreadySubmitted, apperr.InvalidReviewTarget, apperr.InvalidBallot, apperr.ActionLimitReached,
+		apperr.FeatureDisabled, apperr.InvalidCategory, apperr.InvalidStake:
+		return true
+	}
+	return false
+}
+
+func recordViolation(playerId uuid.UUID, roomId uuid.UUID, err error) {
+	violationsMutex.Lock()
+	defer violationsMutex.Unlock()
+	violations[playerId] += 1
+	count := violations[playerId]
+	if count%ViolationsWarningThreshold == 0 {
+		log.Warn().
+			Str("player", playerId.String()).
+			Str("room", roomId.String()).
+			Uint("violations", count).
+			Err(err).
+			Msg("Player keeps sending invalid commands")
+	}
+}
+
+func GetViolations() map[uuid.UUID]uint {
+	violationsMutex.Lock()
+	defer violationsMutex.Unlock()
+	return maps.Clone(violations)
+}
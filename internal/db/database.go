@@ -9,14 +9,17 @@ import (
 
 var Db gorm.DB
 
+var Path = "pitch-perfect-server.db"
+
 func Init() {
-	db, err := gorm.Open(sqlite.Open("pitch-perfect-server.db"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(Path), &gorm.Config{})
 	if err != nil {
 		panic("failed to connect database")
 	}
 
 	// Migrate the schema
-	err = db.AutoMigrate(&entities.Player{}, &entities.Room{}, &entities.Category{}, &entities.Word{}, &entities.Phrase{})
+	err = db.AutoMigrate(&entities.Player{}, &entities.Room{}, &entities.Category{}, &entities.Word{}, &entities.Phrase{},
+		&entities.Game{}, &entities.GameTurn{}, &entities.GamePlayerResult{}, &entities.RoomLogEntry{}, &entities.RoomCheckpoint{})
 	if err != nil {
 
 		log.Error().Msg("Impossible to migrate tables")
@@ -0,0 +1,23 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type Game struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	EndedAt   *time.Time
+	RoomId    uuid.UUID    `gorm:"index"`
+	Settings  RoomSettings `gorm:"embedded;embeddedPrefix:settings_"`
+	Version   string
+	Seed      int64
+	LogSeq    uint64
+	EndSeq    uint64
+	Trends    string `json:"-"`
+	Shocks    string `json:"-"`
+	Turns     []GameTurn
+	Results   []GamePlayerResult
+}
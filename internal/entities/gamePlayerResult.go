@@ -0,0 +1,15 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type GamePlayerResult struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	GameId    uuid.UUID `gorm:"index"`
+	PlayerId  uuid.UUID `gorm:"index"`
+	Score     uint
+	Rank      uint
+}
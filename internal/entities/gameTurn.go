@@ -0,0 +1,18 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type GameTurn struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	GameId    uuid.UUID `gorm:"index"`
+	Number    uint
+	PhraseId  uint
+	Cards     map[uuid.UUID][]uint `gorm:"serializer:json"`
+	Votes     map[uuid.UUID]uint   `gorm:"serializer:json"`
+	Trends    map[uint]uint        `gorm:"serializer:json"`
+	Points    map[uuid.UUID]uint   `gorm:"serializer:json"`
+}
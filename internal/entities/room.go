@@ -12,7 +12,9 @@ type Room struct {
 	UpdatedAt    time.Time
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
 	Name         string
+	HostId       uuid.UUID
 	Players      []Player
 	State        uint
-	PlayersReady []uuid.UUID `gorm:"-"`
+	Settings     RoomSettings `gorm:"embedded;embeddedPrefix:settings_"`
+	PlayersReady []uuid.UUID  `gorm:"-"`
 }
@@ -0,0 +1,14 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type RoomCheckpoint struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	RoomId    uuid.UUID `gorm:"index"`
+	Seq       uint64
+	State     string
+}
@@ -0,0 +1,17 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type RoomLogEntry struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	RoomId    uuid.UUID `gorm:"index:idx_room_log_seq"`
+	Seq       uint64    `gorm:"index:idx_room_log_seq"`
+	Kind      string
+	Type      uint
+	PlayerId  uuid.UUID
+	Payload   string
+}
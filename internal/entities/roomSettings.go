@@ -0,0 +1,32 @@
+package entities
+
+// RoomSettings timeouts are expressed in seconds.
+type RoomSettings struct {
+	Turns            uint
+	HandSize         uint
+	MinPlayers       uint
+	MaxPlayers       uint
+	ReadyTimeout     uint
+	SelectionTimeout uint
+	ReviewTimeout    uint
+	WinnerMultiplier uint
+	ReconnectGrace   uint
+	Scoring          string
+	TiePolicy        string
+	Winners          uint
+	ReviewMode       string
+	ReviewBudget     uint
+	AnonymousReview  bool
+	NoPhraseRepeats  bool
+	MulliganCards    uint
+	Swaps            uint
+	SwapCost         uint
+	Shocks           uint
+	ShockMagnitude   uint
+	Betting          bool
+	MaxStake         uint
+	Forecasts        bool
+	ForecastCost     uint
+	RematchTimeout   uint
+	Seed             int64
+}